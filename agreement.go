@@ -0,0 +1,78 @@
+package verifactu
+
+import (
+	"slices"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/tax"
+)
+
+// Invoice tax extensions used to provide the billing agreement and coupon
+// details per document. GOBL does not define them, so they are local to this
+// package and take precedence over the client and generate options.
+const (
+	// ExtKeyAgreement is the registration number of the billing agreement.
+	ExtKeyAgreement cbc.Key = "es-verifactu-agreement"
+	// ExtKeyAgreementSystem is the ID of the system covered by the agreement.
+	ExtKeyAgreementSystem cbc.Key = "es-verifactu-agreement-system"
+	// ExtKeyCoupon flags corrective invoices issued through a coupon with "S".
+	ExtKeyCoupon cbc.Key = "es-verifactu-coupon"
+)
+
+// couponDocTypes are the only invoice types that may be flagged as coupons.
+var couponDocTypes = []string{"R1", "R5"}
+
+// WithBillingAgreement sets the registration number of the billing agreement
+// authorised by the AEAT under which the client issues invoices, alongside the
+// optional ID of the invoicing system covered by the agreement. Both are
+// included in every registration generated by the client, unless the invoice
+// provides its own through the ExtKeyAgreement extension.
+func WithBillingAgreement(number, systemID string) Option {
+	return func(c *Client) {
+		c.agreement = number
+		c.agreementSystem = systemID
+	}
+}
+
+// WithCoupon flags the registration as a correction made through a coupon,
+// discount or bonus issued to the customer after the original invoice. Only
+// R1 and R5 corrective invoices may be flagged. The ExtKeyCoupon extension
+// may be used instead to flag the invoice itself.
+func WithCoupon() GenerateOption {
+	return func(o *generateOptions) {
+		o.coupon = "S"
+	}
+}
+
+// applyAgreement copies the billing agreement and coupon details into the
+// registration, preferring those in the invoice's extensions over the client
+// and generate options, and checking the coupon is allowed for the type of
+// invoice.
+func (c *Client) applyAgreement(inv *bill.Invoice, reg *InvoiceRegistration, o *generateOptions) error {
+	var ext tax.Extensions
+	if inv.Tax != nil {
+		ext = inv.Tax.Ext
+	}
+	if num := ext[ExtKeyAgreement]; num != "" {
+		reg.NumRegistroAcuerdoFacturacion = num.String()
+		reg.IdAcuerdoSistemaInformatico = ext[ExtKeyAgreementSystem].String()
+	} else {
+		reg.NumRegistroAcuerdoFacturacion = c.agreement
+		reg.IdAcuerdoSistemaInformatico = c.agreementSystem
+	}
+	coupon := o.coupon
+	if v := ext[ExtKeyCoupon]; v != "" {
+		if !v.In("S", "N") {
+			return ErrValidation.WithMessage("coupon extension must be S or N")
+		}
+		coupon = v.String()
+	}
+	if coupon != "" {
+		if coupon == "S" && !slices.Contains(couponDocTypes, reg.TipoFactura) {
+			return ErrValidation.WithMessage("coupons are only allowed in R1 or R5 invoices")
+		}
+		reg.Cupon = coupon
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package verifactu_test
+
+import (
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBillingAgreement(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+
+	t.Run("included in registrations", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBillingAgreement("AC0000000001", "SIS01"),
+		)
+		require.NoError(t, err)
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "AC0000000001", reg.NumRegistroAcuerdoFacturacion)
+		assert.Equal(t, "SIS01", reg.IdAcuerdoSistemaInformatico)
+
+		data, err := reg.Bytes()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "<sum1:NumRegistroAcuerdoFacturacion>AC0000000001</sum1:NumRegistroAcuerdoFacturacion>")
+		assert.Contains(t, string(data), "<sum1:IdAcuerdoSistemaInformatico>SIS01</sum1:IdAcuerdoSistemaInformatico>")
+	})
+
+	t.Run("not included by default", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Empty(t, reg.NumRegistroAcuerdoFacturacion)
+		assert.Empty(t, reg.IdAcuerdoSistemaInformatico)
+	})
+
+	t.Run("from extensions", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBillingAgreement("AC0000000001", "SIS01"),
+		)
+		require.NoError(t, err)
+		env := test.LoadEnvelope("inv-base.json")
+		inv := env.Extract().(*bill.Invoice)
+		inv.Tax.Ext = inv.Tax.Ext.Merge(tax.Extensions{
+			verifactu.ExtKeyAgreement: "AC0000000002",
+		})
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "AC0000000002", reg.NumRegistroAcuerdoFacturacion)
+		assert.Empty(t, reg.IdAcuerdoSistemaInformatico)
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBillingAgreement("AC00000000000001", ""),
+		)
+		require.NoError(t, err)
+		_, err = c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+		assert.ErrorContains(t, err, "NumRegistroAcuerdoFacturacion")
+	})
+}
+
+func TestCoupon(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+	require.NoError(t, err)
+
+	t.Run("corrective invoice", func(t *testing.T) {
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("cred-note-base.json"), nil, verifactu.WithCoupon())
+		require.NoError(t, err)
+		assert.Equal(t, "R1", reg.TipoFactura)
+		assert.Equal(t, "S", reg.Cupon)
+	})
+
+	t.Run("not set by default", func(t *testing.T) {
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("cred-note-base.json"), nil)
+		require.NoError(t, err)
+		assert.Empty(t, reg.Cupon)
+	})
+
+	t.Run("standard invoice", func(t *testing.T) {
+		_, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil, verifactu.WithCoupon())
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+		assert.ErrorContains(t, err, "R1 or R5")
+	})
+
+	t.Run("from extensions", func(t *testing.T) {
+		env := test.LoadEnvelope("cred-note-base.json")
+		inv := env.Extract().(*bill.Invoice)
+		inv.Tax.Ext = inv.Tax.Ext.Merge(tax.Extensions{verifactu.ExtKeyCoupon: "S"})
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "S", reg.Cupon)
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+
+		env = test.LoadEnvelope("inv-base.json")
+		inv = env.Extract().(*bill.Invoice)
+		inv.Tax.Ext = inv.Tax.Ext.Merge(tax.Extensions{verifactu.ExtKeyCoupon: "S"})
+		_, err = c.RegisterInvoice(env, nil)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+		assert.ErrorContains(t, err, "R1 or R5")
+
+		env = test.LoadEnvelope("cred-note-base.json")
+		inv = env.Extract().(*bill.Invoice)
+		inv.Tax.Ext = inv.Tax.Ext.Merge(tax.Extensions{verifactu.ExtKeyCoupon: "X"})
+		_, err = c.RegisterInvoice(env, nil)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+	})
+}
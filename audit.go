@@ -0,0 +1,126 @@
+package verifactu
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Exchange contains the complete details of a single request sent to the
+// AEAT and the response received. Request and response bodies are provided
+// exactly as they were sent and received, so that they may be persisted
+// as evidence.
+type Exchange struct {
+	// ID is a unique identifier used to correlate log entries and stored
+	// payloads.
+	ID string
+	// Operation performed.
+	Operation Operation
+	// URL the request was sent to.
+	URL string
+	// NIFs of the parties involved in the request, the issuer first, followed
+	// by the representative if any.
+	NIFs []string
+	// Request contains the raw body sent.
+	Request []byte
+	// Response contains the raw body received, if any.
+	Response []byte
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Started is when the request was sent.
+	Started time.Time
+	// Duration is the time taken to receive the response.
+	Duration time.Duration
+	// Err contains any error that occurred during the exchange.
+	Err error
+}
+
+// AuditHook is called after every exchange with the AEAT, successful or not.
+// Hooks are called synchronously and must not modify the exchange's payloads.
+type AuditHook func(ctx context.Context, ex *Exchange)
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a copy of the context with the ID to use for the
+// exchanges performed with it. When not set, a new UUID will be used for
+// each exchange.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// redactedElements contains the local names of the XML elements whose
+// contents will be hidden from logs when redaction is enabled.
+var redactedElements = []string{
+	"NombreRazon",
+	"NombreRazonEmisor",
+	"NIF",
+	"NIFPresentador",
+	"NifRepresentante",
+	"IDEmisorFactura",
+	"IDEmisorFacturaAnulada",
+	"ID",
+}
+
+var redactRegexp = regexp.MustCompile(
+	`(<(?:[\w]+:)?(?:` + strings.Join(redactedElements, "|") + `)>)[^<]*(</)`,
+)
+
+const redacted = "***"
+
+// redactXML hides the names and identifiers contained in the XML document.
+func redactXML(data []byte) []byte {
+	return redactRegexp.ReplaceAll(data, []byte("${1}"+redacted+"${2}"))
+}
+
+// redactValue hides most of the value, leaving the last characters so that
+// entries can still be told apart.
+func redactValue(v string) string {
+	if len(v) <= 3 {
+		return redacted
+	}
+	return redacted + v[len(v)-3:]
+}
+
+// logExchange writes the details of the exchange to the logger.
+func logExchange(ctx context.Context, l *slog.Logger, ex *Exchange, redact bool) {
+	nifs := make([]string, len(ex.NIFs))
+	for i, n := range ex.NIFs {
+		if redact {
+			n = redactValue(n)
+		}
+		nifs[i] = n
+	}
+	attrs := []slog.Attr{
+		slog.String("id", ex.ID),
+		slog.String("operation", string(ex.Operation)),
+		slog.String("url", ex.URL),
+		slog.Any("nifs", nifs),
+		slog.Int("status", ex.StatusCode),
+		slog.Duration("duration", ex.Duration),
+	}
+	if ex.Err != nil {
+		msg := ex.Err.Error()
+		if redact {
+			msg = string(redactXML([]byte(msg)))
+		}
+		attrs = append(attrs, slog.String("error", msg))
+		l.LogAttrs(ctx, slog.LevelError, "verifactu exchange failed", attrs...)
+	} else {
+		l.LogAttrs(ctx, slog.LevelInfo, "verifactu exchange", attrs...)
+	}
+
+	if !l.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	req, res := ex.Request, ex.Response
+	if redact {
+		req, res = redactXML(req), redactXML(res)
+	}
+	l.LogAttrs(ctx, slog.LevelDebug, "verifactu exchange payloads",
+		slog.String("id", ex.ID),
+		slog.String("request", string(req)),
+		slog.String("response", string(res)),
+	)
+}
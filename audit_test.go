@@ -0,0 +1,119 @@
+package verifactu_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditHook(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	env := test.LoadEnvelope("inv-base.json")
+
+	srv := verifactutest.NewServer()
+	defer srv.Close()
+
+	var exchanges []*verifactu.Exchange
+	logs := new(bytes.Buffer)
+	c, err := verifactu.New(testSoftware,
+		verifactu.WithCurrentTime(ts),
+		verifactu.WithBaseURL(srv.URL),
+		verifactu.WithAuditHook(func(_ context.Context, ex *verifactu.Exchange) {
+			exchanges = append(exchanges, ex)
+		}),
+		verifactu.WithLogger(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		verifactu.WithRedaction(),
+	)
+	require.NoError(t, err)
+
+	ir, err := c.NewEnvelopeInvoiceRequest(env, nil)
+	require.NoError(t, err)
+	data, err := ir.Envelop().Bytes()
+	require.NoError(t, err)
+
+	ctx := verifactu.WithCorrelationID(context.Background(), "req-001")
+	_, err = c.SendInvoiceRequest(ctx, ir)
+	require.NoError(t, err)
+
+	srv.FailNext(&verifactu.Fault{Code: "env:Server", Message: "Codigo[999].Servicio no disponible"})
+	_, err = c.SendInvoiceRequest(context.Background(), ir)
+	require.Error(t, err)
+
+	require.Len(t, exchanges, 2)
+
+	ex := exchanges[0]
+	assert.Equal(t, "req-001", ex.ID)
+	assert.Equal(t, verifactu.OperationRegister, ex.Operation)
+	assert.Equal(t, srv.URL, ex.URL)
+	assert.Equal(t, []string{"B85905495"}, ex.NIFs)
+	assert.Equal(t, data, ex.Request)
+	assert.Contains(t, string(ex.Response), "RespuestaRegFactuSistemaFacturacion")
+	assert.Equal(t, 200, ex.StatusCode)
+	assert.False(t, ex.Started.IsZero())
+	assert.NoError(t, ex.Err)
+
+	ex = exchanges[1]
+	assert.NotEmpty(t, ex.ID)
+	assert.NotEqual(t, "req-001", ex.ID)
+	assert.ErrorIs(t, ex.Err, verifactu.ErrValidation)
+	assert.Equal(t, 500, ex.StatusCode)
+	assert.Contains(t, string(ex.Response), "Servicio no disponible")
+
+	out := logs.String()
+	assert.Contains(t, out, "id=req-001")
+	assert.Contains(t, out, "verifactu exchange failed")
+	assert.Contains(t, out, "***495")
+	assert.NotContains(t, out, "B85905495")
+	assert.NotContains(t, out, "Invopop S.L.")
+}
+
+func TestLogRedactionOnError(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("<x><NIF>B12345678</NIF><NombreRazon>Secret SL</NombreRazon></x>"))
+	}))
+	defer srv.Close()
+
+	var ex *verifactu.Exchange
+	logs := new(bytes.Buffer)
+	c, err := verifactu.New(testSoftware,
+		verifactu.WithCurrentTime(ts),
+		verifactu.WithBaseURL(srv.URL),
+		verifactu.WithAuditHook(func(_ context.Context, e *verifactu.Exchange) {
+			ex = e
+		}),
+		verifactu.WithLogger(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		verifactu.WithRedaction(),
+	)
+	require.NoError(t, err)
+
+	ir, err := c.NewEnvelopeInvoiceRequest(test.LoadEnvelope("inv-base.json"), nil)
+	require.NoError(t, err)
+	_, err = c.SendInvoiceRequest(context.Background(), ir)
+	require.ErrorIs(t, err, verifactu.ErrValidation)
+	assert.NotContains(t, err.Error(), "B12345678")
+
+	require.NotNil(t, ex)
+	assert.Equal(t, 400, ex.StatusCode)
+	assert.Contains(t, string(ex.Response), "B12345678")
+
+	out := logs.String()
+	assert.Contains(t, out, "verifactu exchange failed")
+	assert.Contains(t, out, "***495")
+	assert.NotContains(t, out, "B12345678")
+	assert.NotContains(t, out, "Secret SL")
+	assert.NotContains(t, out, "B85905495")
+}
@@ -60,18 +60,23 @@ func buildDetalleDesglose(c *tax.CategoryTotal, r *tax.RateTotal) (*DetalleDesgl
 		detalle.Impuesto = cat
 	}
 
-	if c.Code == tax.CategoryVAT || c.Code == es.TaxCategoryIGIC {
-		detalle.ClaveRegimen = r.Ext.Get(verifactu.ExtKeyRegime).String()
+	ext := r.Ext
+	if detalle.Impuesto == taxCodeIPSI {
+		ext = ipsiExtensions(r)
 	}
-
-	if r.Ext == nil {
+	if ext == nil {
 		return nil, ErrValidation.WithMessage(fmt.Sprintf("missing tax extensions for rate %s", r.Key))
 	}
 
-	if r.Percent == nil && r.Ext.Has(verifactu.ExtKeyExempt) {
-		detalle.OperacionExenta = r.Ext[verifactu.ExtKeyExempt].String()
-	} else if r.Ext.Has(verifactu.ExtKeyOpClass) {
-		detalle.CalificacionOperacion = r.Ext.Get(verifactu.ExtKeyOpClass).String()
+	// Regimes are only defined for VAT (L8A) and IGIC (L8B).
+	if detalle.Impuesto == taxCodeVAT || detalle.Impuesto == taxCodeIGIC {
+		detalle.ClaveRegimen = ext.Get(verifactu.ExtKeyRegime).String()
+	}
+
+	if ext.Has(verifactu.ExtKeyExempt) && (r.Percent == nil || !ext.Has(verifactu.ExtKeyOpClass)) {
+		detalle.OperacionExenta = ext[verifactu.ExtKeyExempt].String()
+	} else if ext.Has(verifactu.ExtKeyOpClass) {
+		detalle.CalificacionOperacion = ext.Get(verifactu.ExtKeyOpClass).String()
 		switch detalle.CalificacionOperacion {
 		case "S1", "S2":
 			// S1 represents taxed operations; S2 represents reverse-charge operations.
@@ -80,7 +85,9 @@ func buildDetalleDesglose(c *tax.CategoryTotal, r *tax.RateTotal) (*DetalleDesgl
 		}
 	}
 
-	if detalle.Impuesto == taxCodeIPSI || detalle.Impuesto == taxCodeOther || detalle.ClaveRegimen == "06" {
+	// The cost based taxable amount is only used by the special regime for
+	// groups of entities, where it is always the same as the base.
+	if detalle.ClaveRegimen == "06" {
 		detalle.BaseImponibleACoste = r.Base.String()
 	}
 
@@ -104,3 +111,13 @@ func buildDetalleDesglose(c *tax.CategoryTotal, r *tax.RateTotal) (*DetalleDesgl
 
 	return detalle, nil
 }
+
+// ipsiExtensions provides the extensions to use for IPSI rates. GOBL does not
+// normalize IPSI combos as it does for VAT and IGIC, so rates with a percentage
+// and no classification are assumed to be taxed operations.
+func ipsiExtensions(r *tax.RateTotal) tax.Extensions {
+	if r.Ext.Has(verifactu.ExtKeyOpClass) || r.Ext.Has(verifactu.ExtKeyExempt) || r.Percent == nil {
+		return r.Ext
+	}
+	return r.Ext.Merge(tax.Extensions{verifactu.ExtKeyOpClass: "S1"})
+}
@@ -227,6 +227,76 @@ func TestBreakdownConversion(t *testing.T) {
 		assert.Equal(t, "02", dd.Impuesto)
 		assert.Empty(t, dd.ClaveRegimen)
 		assert.Equal(t, "S1", dd.CalificacionOperacion)
+		assert.Empty(t, dd.BaseImponibleACoste)
+	})
+
+	t.Run("ipsi-without-extensions", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-ipsi.json")
+		vc := defaultBreakdownClient(t)
+		req, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		require.Len(t, req.Desglose.DetalleDesglose, 3)
+
+		dd := req.Desglose.DetalleDesglose[0]
+		assert.Equal(t, "02", dd.Impuesto)
+		assert.Empty(t, dd.ClaveRegimen)
+		assert.Equal(t, "S1", dd.CalificacionOperacion)
+		assert.Equal(t, "10", dd.TipoImpositivo)
+		assert.Equal(t, "20.00", dd.CuotaRepercutida)
+
+		dd = req.Desglose.DetalleDesglose[2]
+		assert.Equal(t, "E1", dd.OperacionExenta)
+		assert.Empty(t, dd.CalificacionOperacion)
+		assert.Empty(t, dd.TipoImpositivo)
+		assert.Empty(t, dd.CuotaRepercutida)
+	})
+
+	t.Run("igic-tax", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-igic.json")
+		vc := defaultBreakdownClient(t)
+		req, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		require.Len(t, req.Desglose.DetalleDesglose, 4)
+
+		rates := make([]string, 0, 3)
+		for _, dd := range req.Desglose.DetalleDesglose[:3] {
+			assert.Equal(t, "03", dd.Impuesto)
+			assert.Equal(t, "01", dd.ClaveRegimen)
+			assert.Equal(t, "S1", dd.CalificacionOperacion)
+			rates = append(rates, dd.TipoImpositivo)
+		}
+		assert.Equal(t, []string{"7.0", "3.0", "15"}, rates)
+
+		dd := req.Desglose.DetalleDesglose[3]
+		assert.Equal(t, "03", dd.Impuesto)
+		assert.Equal(t, "01", dd.ClaveRegimen)
+		assert.Equal(t, "E1", dd.OperacionExenta)
+		assert.Equal(t, "144.00", req.CuotaTotal.String())
+	})
+
+	t.Run("vat-and-igic", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-vat-igic.json")
+		vc := defaultBreakdownClient(t)
+		req, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		require.Len(t, req.Desglose.DetalleDesglose, 2)
+		assert.Equal(t, "03", req.Desglose.DetalleDesglose[0].Impuesto)
+		assert.Equal(t, "70.00", req.Desglose.DetalleDesglose[0].CuotaRepercutida)
+		assert.Equal(t, "01", req.Desglose.DetalleDesglose[1].Impuesto)
+		assert.Equal(t, "105.00", req.Desglose.DetalleDesglose[1].CuotaRepercutida)
+		assert.Equal(t, "1675.00", req.ImporteTotal.String())
+	})
+
+	t.Run("group-of-entities", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		inv.Lines[0].Taxes[0].Ext[addon.ExtKeyRegime] = "06"
+		require.NoError(t, env.Calculate())
+		vc := defaultBreakdownClient(t)
+		req, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		dd := req.Desglose.DetalleDesglose[0]
+		assert.Equal(t, "06", dd.ClaveRegimen)
+		assert.Equal(t, "1800.00", dd.BaseImponibleACoste)
 	})
 
 	t.Run("antiques", func(t *testing.T) {
@@ -12,12 +12,16 @@ import (
 const FingerprintType = "01"
 
 // ChainData contains the fields of this invoice that will be required for fingerprinting
-// the _next_ invoice. JSON tags are provided to help with serialization.
+// the _next_ invoice. JSON tags are provided to help with serialization. The
+// timestamp and environment are not part of the fingerprint, but ensure the next
+// record is never generated with an earlier time or in a different environment.
 type ChainData struct {
-	IDIssuer    string `json:"issuer"`
-	NumSeries   string `json:"num_series"`
-	IssueDate   string `json:"issue_date"`
-	Fingerprint string `json:"fingerprint"`
+	IDIssuer    string      `json:"issuer"`
+	NumSeries   string      `json:"num_series"`
+	IssueDate   string      `json:"issue_date"`
+	Fingerprint string      `json:"fingerprint"`
+	Timestamp   string      `json:"timestamp,omitempty"`
+	Environment Environment `json:"environment,omitempty"`
 }
 
 // Encadenamiento contains chaining information between invoice documents
@@ -36,10 +40,12 @@ type RegistroAnterior struct {
 
 // EventChainData contains the fields of this event that will be required for
 // fingerprinting the _next_ event. JSON tags are provided to help with serialization.
+// The environment is not part of the fingerprint.
 type EventChainData struct {
-	EventType           string `json:"event_type"`
-	GenerationTimestamp string `json:"generation_timestamp"`
-	Fingerprint         string `json:"fingerprint"`
+	EventType           string      `json:"event_type"`
+	GenerationTimestamp string      `json:"generation_timestamp"`
+	Fingerprint         string      `json:"fingerprint"`
+	Environment         Environment `json:"environment,omitempty"`
 }
 
 // EventChaining contains chaining information between event registrations
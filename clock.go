@@ -0,0 +1,144 @@
+package verifactu
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl.verifactu/pkg/noverifactu"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/schema"
+	"github.com/invopop/gobl/tax"
+)
+
+// anomalyTypeDateBeforePrevious is the L1E anomaly type used when a record's
+// timestamp is earlier than the previous record's.
+const anomalyTypeDateBeforePrevious cbc.Code = "11"
+
+// Clock provides the current time used to timestamp records.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc allows a function to be used as a Clock.
+type ClockFunc func() time.Time
+
+// Now returns the time provided by the function.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// fixedClock always returns the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// WithClock defines the clock used to timestamp records, instead of the
+// system clock.
+func WithClock(clk Clock) Option {
+	return func(c *Client) {
+		c.clock = clk
+	}
+}
+
+// DateAnomalyError is returned when the clock provides a time earlier than
+// the timestamp of the previous record in the chain, so no record was
+// generated.
+//
+// For invoice records, Event contains a GOBL envelope with a bill status
+// describing the date traceability anomaly, ready to be added to the event
+// chain with RegisterEvent. Event is always nil when the error is returned by
+// RegisterEvent: an anomaly event would be timestamped with the same clock,
+// and so be rejected for the same reason. In that case the clock must be
+// fixed before any further events are registered.
+type DateAnomalyError struct {
+	// Previous is the timestamp of the previous record in the chain.
+	Previous time.Time
+	// Current is the time provided by the clock.
+	Current time.Time
+	// Event contains the anomaly event to register, for invoice records only.
+	Event *gobl.Envelope
+}
+
+// Error describes the anomaly.
+func (e *DateAnomalyError) Error() string {
+	return fmt.Sprintf("%s: current time %s is before previous record at %s",
+		ErrDateAnomaly.Key(),
+		formatDateTimeZone(e.Current),
+		formatDateTimeZone(e.Previous),
+	)
+}
+
+// Unwrap allows the error to be checked with errors.Is(err, ErrDateAnomaly).
+func (e *DateAnomalyError) Unwrap() error {
+	return ErrDateAnomaly
+}
+
+// checkChainTime ensures the record's timestamp is not earlier than that of
+// the previous record. Chain data without a timestamp is not checked.
+func checkChainTime(prev string, ts time.Time) *DateAnomalyError {
+	if prev == "" {
+		return nil
+	}
+	pt, err := time.Parse(time.RFC3339, prev)
+	if err != nil || !ts.Before(pt) {
+		return nil
+	}
+	return &DateAnomalyError{
+		Previous: pt,
+		Current:  ts,
+	}
+}
+
+// checkInvoiceChainTime is like checkChainTime, but also prepares the anomaly
+// event for the invoice that could not be registered.
+func checkInvoiceChainTime(inv *bill.Invoice, prev *ChainData, ts time.Time) error {
+	if prev == nil {
+		return nil
+	}
+	de := checkChainTime(prev.Timestamp, ts)
+	if de == nil {
+		return nil
+	}
+	env, err := newDateAnomalyEvent(inv, ts)
+	if err != nil {
+		return fmt.Errorf("preparing date anomaly event: %w", err)
+	}
+	de.Event = env
+	return de
+}
+
+// newDateAnomalyEvent prepares an envelope with the invoice anomaly event
+// for a record that would have been timestamped before the previous one.
+func newDateAnomalyEvent(inv *bill.Invoice, ts time.Time) (*gobl.Envelope, error) {
+	obj, err := schema.NewObject(&noverifactu.InvoiceAnomaly{
+		Type: anomalyTypeDateBeforePrevious,
+		Invoice: &noverifactu.AnomalousInvoice{
+			IssuerTaxCode: inv.Supplier.TaxID.Code.String(),
+			Code:          invoiceNumber(inv.Series, inv.Code),
+			IssueDate:     inv.IssueDate,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	status := &bill.Status{
+		Regime:    tax.WithRegime(l10n.ES.Tax()),
+		Type:      bill.StatusTypeSystem,
+		IssueDate: cal.DateOf(ts),
+		Supplier:  inv.Supplier,
+		Lines: []*bill.StatusLine{
+			{
+				Key:         noverifactu.KeyInvoiceAnomaly,
+				Description: "Record time before previous record",
+				Complements: []*schema.Object{obj},
+			},
+		},
+	}
+	return gobl.Envelop(status)
+}
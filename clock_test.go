@@ -0,0 +1,95 @@
+package verifactu_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClock(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+
+	now := ts
+	clk := verifactu.ClockFunc(func() time.Time { return now })
+	c, err := verifactu.New(testSoftware, verifactu.WithClock(clk))
+	require.NoError(t, err)
+
+	t.Run("uses clock", func(t *testing.T) {
+		now = ts
+		assert.Equal(t, ts, c.CurrentTime())
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "2024-11-26T05:00:00+01:00", reg.FechaHoraHusoGenRegistro)
+		assert.Equal(t, reg.FechaHoraHusoGenRegistro, reg.ChainData().Timestamp)
+	})
+
+	t.Run("same or later time", func(t *testing.T) {
+		now = ts
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		_, err = c.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), reg.ChainData())
+		require.NoError(t, err)
+		now = ts.Add(time.Second)
+		_, err = c.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), reg.ChainData())
+		require.NoError(t, err)
+	})
+
+	t.Run("clock goes backwards", func(t *testing.T) {
+		now = ts
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+
+		now = ts.Add(-time.Minute)
+		env := test.LoadEnvelope("inv-tax-inc.json")
+		_, err = c.RegisterInvoice(env, reg.ChainData())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, verifactu.ErrDateAnomaly)
+		assert.Empty(t, env.Head.Stamps)
+
+		var de *verifactu.DateAnomalyError
+		require.True(t, errors.As(err, &de))
+		assert.True(t, de.Previous.Equal(ts))
+		assert.True(t, de.Current.Equal(now))
+		require.NotNil(t, de.Event)
+
+		evt, err := c.RegisterEvent(de.Event, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "04", evt.Event.EventType)
+		require.NotNil(t, evt.Event.EventData.InvoiceAnomalyDetection)
+		assert.Equal(t, "11", evt.Event.EventData.InvoiceAnomalyDetection.AnomalyType)
+		assert.Equal(t, "SAMPLE-003", evt.Event.EventData.InvoiceAnomalyDetection.AnomalousInvoice.InvoiceNumber)
+
+		_, err = c.CancelInvoice(test.LoadEnvelope("inv-base.json"), reg.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrDateAnomaly)
+	})
+
+	t.Run("chain data without timestamp", func(t *testing.T) {
+		now = ts
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		prev := reg.ChainData()
+		prev.Timestamp = ""
+		now = ts.Add(-time.Minute)
+		_, err = c.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), prev)
+		assert.NoError(t, err)
+	})
+
+	t.Run("events", func(t *testing.T) {
+		now = ts
+		evt, err := c.RegisterEvent(test.LoadEnvelope("status-system-startup.json"), nil)
+		require.NoError(t, err)
+
+		now = ts.Add(-time.Minute)
+		_, err = c.RegisterEvent(test.LoadEnvelope("status-system-shutdown.json"), evt.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrDateAnomaly)
+		var de *verifactu.DateAnomalyError
+		require.True(t, errors.As(err, &de))
+		assert.Nil(t, de.Event)
+	})
+}
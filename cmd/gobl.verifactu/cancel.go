@@ -4,18 +4,21 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/invopop/gobl"
 	verifactu "github.com/invopop/gobl.verifactu"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/xmldsig"
 	"github.com/spf13/cobra"
 )
 
 type cancelOpts struct {
 	*rootOpts
-	previous string
+	previous    string
+	generatedBy string
 }
 
 func cancel(o *rootOpts) *cancelOpts {
@@ -33,6 +36,7 @@ func (c *cancelOpts) cmd() *cobra.Command {
 	c.prepareFlags(f)
 
 	f.StringVar(&c.previous, "prev", "", "Previous document fingerprint to chain with")
+	f.StringVar(&c.generatedBy, "generated-by", "", "Who generated the cancellation: E (issuer), D (recipient) or T (third party)")
 
 	return cmd
 }
@@ -59,9 +63,11 @@ func (c *cancelOpts) runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("loading certificate: %w", err)
 	}
 
-	opts := []verifactu.Option{
-		verifactu.WithCertificate(cert),
+	opts, err := c.clientOptions()
+	if err != nil {
+		return err
 	}
+	opts = append(opts, verifactu.WithCertificate(cert))
 
 	if c.sign {
 		opts = append(opts, verifactu.WithSigning())
@@ -86,10 +92,25 @@ func (c *cancelOpts) runE(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	req, err := vc.CancelInvoice(env, prev)
+	var genOpts []verifactu.GenerateOption
+	switch cbc.Code(c.generatedBy) {
+	case cbc.CodeEmpty:
+		// use the invoice's issuer type
+	case verifactu.GeneratedByCodeIssuer:
+		genOpts = append(genOpts, verifactu.GeneratedByIssuer())
+	case verifactu.GeneratedByCodeRecipient:
+		genOpts = append(genOpts, verifactu.GeneratedByRecipient())
+	case verifactu.GeneratedByCodeThirdParty:
+		genOpts = append(genOpts, verifactu.GeneratedByThirdParty(nil))
+	default:
+		return fmt.Errorf("invalid generated-by code: %s", c.generatedBy)
+	}
+
+	req, err := vc.CancelInvoice(env, prev, genOpts...)
 	if err != nil {
 		return fmt.Errorf("generating invoice cancellation: %w", err)
 	}
+	printWarnings(req.Warnings())
 
 	inv := env.Extract().(*bill.Invoice)
 	ir, err := vc.NewInvoiceRequest(inv.Supplier)
@@ -99,6 +120,12 @@ func (c *cancelOpts) runE(cmd *cobra.Command, args []string) error {
 	ir.AddCancellation(req)
 
 	res, err := vc.SendInvoiceRequest(cmd.Context(), ir)
+	if serr := new(verifactu.SubmissionError); errors.As(err, &serr) {
+		// Show the response so that accepted lines are not lost
+		if rd, err := res.Bytes(); err == nil {
+			fmt.Print(string(rd))
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("sending cancellation: %w", err)
 	}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/invopop/gobl"
 	verifactu "github.com/invopop/gobl.verifactu"
@@ -15,6 +16,8 @@ import (
 
 type convertOpts struct {
 	*rootOpts
+	preview bool
+	coupon  bool
 }
 
 func convert(o *rootOpts) *convertOpts {
@@ -31,6 +34,9 @@ func (c *convertOpts) cmd() *cobra.Command {
 	f := cmd.Flags()
 	c.prepareFlags(f)
 
+	f.BoolVar(&c.preview, "preview", false, "Show diagnostics without signing or stamping the invoice")
+	f.BoolVar(&c.coupon, "coupon", false, "Flag the corrective invoice as issued through a coupon")
+
 	return cmd
 }
 
@@ -57,7 +63,10 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("unmarshaling gobl envelope: %w", err)
 	}
 
-	var opts []verifactu.Option
+	opts, err := c.clientOptions()
+	if err != nil {
+		return err
+	}
 
 	if c.cert != "" {
 		cert, err := xmldsig.LoadCertificate(c.cert, c.password)
@@ -76,10 +85,26 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("creating verifactu client: %w", err)
 	}
 
+	var genOpts []verifactu.GenerateOption
+	if c.coupon {
+		genOpts = append(genOpts, verifactu.WithCoupon())
+	}
+
 	var reg interface{ Bytes() ([]byte, error) }
 	switch env.Extract().(type) {
 	case *bill.Invoice:
-		reg, err = vc.RegisterInvoice(env, nil)
+		var r *verifactu.InvoiceRegistration
+		if c.preview {
+			var diags []*verifactu.Diagnostic
+			r, diags, err = vc.Preview(env, nil, genOpts...)
+			printDiagnostics(diags)
+		} else {
+			r, err = vc.RegisterInvoice(env, nil, genOpts...)
+			if err == nil {
+				printWarnings(r.Warnings())
+			}
+		}
+		reg = r
 	case *bill.Status:
 		reg, err = vc.RegisterEvent(env, nil)
 	default:
@@ -101,3 +126,18 @@ func (c *convertOpts) runE(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+// printWarnings shows the changes made to the document's data on stderr.
+func printWarnings(warnings []*verifactu.Warning) {
+	for _, w := range warnings {
+		_, _ = fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+}
+
+// printDiagnostics shows the differences between the invoice and the
+// registration on stderr.
+func printDiagnostics(diags []*verifactu.Diagnostic) {
+	for _, d := range diags {
+		_, _ = fmt.Fprintf(os.Stderr, "diagnostic: %s\n", d)
+	}
+}
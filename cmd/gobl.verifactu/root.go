@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"time"
 
 	verifactu "github.com/invopop/gobl.verifactu"
 	_ "github.com/joho/godotenv/autoload"
@@ -19,8 +22,12 @@ type rootOpts struct {
 	swIDSistemaInformatico string
 	swVersion              string
 	swNumeroInstalacion    string
+	agreement              string
+	agreementSystem        string
+	timezone               string
 	production             bool
 	sign                   bool
+	debug                  bool
 }
 
 func root() *rootOpts {
@@ -51,8 +58,12 @@ func (o *rootOpts) prepareFlags(f *pflag.FlagSet) {
 	f.StringVar(&o.swVersion, "sw-version", os.Getenv("SOFTWARE_VERSION"), "Version of the software")
 	f.StringVar(&o.swIDSistemaInformatico, "sw-id", os.Getenv("SOFTWARE_ID_SISTEMA_INFORMATICO"), "ID of the software system")
 	f.StringVar(&o.swNumeroInstalacion, "sw-inst", os.Getenv("SOFTWARE_NUMERO_INSTALACION"), "Number of the software installation")
+	f.StringVar(&o.agreement, "agreement", os.Getenv("BILLING_AGREEMENT"), "Registration number of the billing agreement")
+	f.StringVar(&o.agreementSystem, "agreement-system", os.Getenv("BILLING_AGREEMENT_SYSTEM"), "ID of the system covered by the billing agreement")
+	f.StringVar(&o.timezone, "timezone", os.Getenv("TIMEZONE"), "Time zone of the installation, such as Atlantic/Canary")
 	f.BoolVarP(&o.production, "production", "p", false, "Production environment")
 	f.BoolVar(&o.sign, "sign", false, "Enable XML digital signatures on records")
+	f.BoolVar(&o.debug, "debug", os.Getenv("DEBUG") == "true", "Log the requests and responses exchanged with the AEAT")
 }
 
 func (o *rootOpts) software() verifactu.Software {
@@ -69,6 +80,26 @@ func (o *rootOpts) software() verifactu.Software {
 	}
 }
 
+// clientOptions provides the client options common to all commands.
+func (o *rootOpts) clientOptions() ([]verifactu.Option, error) {
+	var opts []verifactu.Option
+	if o.agreement != "" {
+		opts = append(opts, verifactu.WithBillingAgreement(o.agreement, o.agreementSystem))
+	}
+	if o.timezone != "" {
+		loc, err := time.LoadLocation(o.timezone)
+		if err != nil {
+			return nil, fmt.Errorf("loading timezone: %w", err)
+		}
+		opts = append(opts, verifactu.WithLocation(loc))
+	}
+	if o.debug {
+		h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		opts = append(opts, verifactu.WithLogger(slog.New(h)))
+	}
+	return opts, nil
+}
+
 func (o *rootOpts) outputFilename(args []string) string {
 	if len(args) >= 2 && args[1] != "-" {
 		return args[1]
@@ -4,6 +4,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/invopop/gobl"
@@ -58,9 +59,11 @@ func (c *sendOpts) runE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	opts := []verifactu.Option{
-		verifactu.WithCertificate(cert),
+	opts, err := c.clientOptions()
+	if err != nil {
+		return err
 	}
+	opts = append(opts, verifactu.WithCertificate(cert))
 
 	if c.sign {
 		opts = append(opts, verifactu.WithSigning())
@@ -89,8 +92,18 @@ func (c *sendOpts) runE(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("envelope invoice request: %w", err)
 	}
+	printWarnings(ir.Warnings())
+	for _, l := range ir.Lines {
+		printWarnings(l.Registration.Warnings())
+	}
 
 	res, err := tc.SendInvoiceRequest(cmd.Context(), ir)
+	if serr := new(verifactu.SubmissionError); errors.As(err, &serr) {
+		// Show the response so that accepted lines are not lost
+		if rd, err := res.Bytes(); err == nil {
+			fmt.Print(string(rd))
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("send invoice request: %w", err)
 	}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/xml"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/invopop/gobl/uuid"
 	"github.com/invopop/xmldsig"
 )
 
@@ -27,67 +30,195 @@ const (
 	BaseURLTestingWithSeal    = "https://prewww10.aeat.es/wlpl/TIKE-CONT/ws/SistemaFacturacion/VerifactuSOAP"
 )
 
+// Operation identifies the SOAP operations offered by the VeriFactu service
+// so that specific endpoints may be defined for each.
+type Operation string
+
+// Supported operations
+const (
+	OperationRegister Operation = "RegFactuSistemaFacturacion"
+	OperationQuery    Operation = "ConsultaFactuSistemaFacturacion"
+)
+
+// connectionOptions contains the transport configuration provided by the
+// client options.
+type connectionOptions struct {
+	baseURL    string
+	endpoints  map[Operation]string
+	httpClient *http.Client
+	transport  http.RoundTripper
+	timeout    time.Duration
+	logger     *slog.Logger
+	audit      AuditHook
+	redact     bool
+}
+
+// custom returns true if any of the options would allow a connection to be
+// made without a certificate.
+func (o *connectionOptions) custom() bool {
+	return o.baseURL != "" || len(o.endpoints) > 0 || o.httpClient != nil || o.transport != nil
+}
+
 // connection defines what is expected from a connection to a gateway.
 type connection struct {
-	client *resty.Client
+	client    *resty.Client
+	baseURL   string
+	endpoints map[Operation]string
+	timeout   time.Duration
+	logger    *slog.Logger
+	audit     AuditHook
+	redact    bool
 }
 
 // newConnection instantiates and configures a new connection to the VeriFactu gateway.
-func newConnection(env Environment, cert *xmldsig.Certificate, withSeal bool) (*connection, error) {
-	// Prepare the tls configuration
-	tlsConf, err := cert.TLSAuthConfig()
-	if err != nil {
-		return nil, ErrValidation.WithMessage(fmt.Errorf("preparing TLS config: %v", err).Error())
+// When a certificate is provided, it will be added to the TLS configuration of the
+// transport as long as it is an *http.Transport. Other round trippers are expected
+// to handle client authentication themselves.
+func newConnection(env Environment, cert *xmldsig.Certificate, withSeal bool, opts *connectionOptions) (*connection, error) {
+	hc := new(http.Client)
+	if opts.httpClient != nil {
+		*hc = *opts.httpClient
 	}
-	certs, err := x509.SystemCertPool()
-	if err != nil {
-		return nil, fmt.Errorf("preparing cert pool: %w", err)
+	if opts.transport != nil {
+		hc.Transport = opts.transport
+	}
+
+	if cert != nil {
+		// Prepare the tls configuration
+		tlsConf, err := cert.TLSAuthConfig()
+		if err != nil {
+			return nil, ErrValidation.WithMessage(fmt.Errorf("preparing TLS config: %v", err).Error())
+		}
+		certs, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("preparing cert pool: %w", err)
+		}
+		tlsConf.RootCAs = certs
+		tlsConf.Renegotiation = tls.RenegotiateOnceAsClient
+
+		var tr *http.Transport
+		switch t := hc.Transport.(type) {
+		case nil:
+			tr = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			// avoid modifying the transport provided
+			tr = t.Clone()
+			if tr.TLSClientConfig != nil && tr.TLSClientConfig.RootCAs != nil {
+				tlsConf.RootCAs = tr.TLSClientConfig.RootCAs
+			}
+		}
+		if tr != nil {
+			tr.TLSClientConfig = tlsConf
+			hc.Transport = tr
+		}
 	}
-	tlsConf.RootCAs = certs
-	tlsConf.Renegotiation = tls.RenegotiateOnceAsClient
 
 	c := new(connection)
-	c.client = resty.New()
+	c.client = resty.NewWithClient(hc)
+	c.endpoints = opts.endpoints
+	c.timeout = opts.timeout
+	c.logger = opts.logger
+	c.audit = opts.audit
+	c.redact = opts.redact
 
-	switch env {
-	case EnvironmentProduction:
+	switch {
+	case opts.baseURL != "":
+		c.baseURL = opts.baseURL
+	case env == EnvironmentProduction:
 		if withSeal {
-			c.client.SetBaseURL(BaseURLProductionWithSeal)
+			c.baseURL = BaseURLProductionWithSeal
 		} else {
-			c.client.SetBaseURL(BaseURLProduction)
+			c.baseURL = BaseURLProduction
 		}
 	default:
 		if withSeal {
-			c.client.SetBaseURL(BaseURLTestingWithSeal)
+			c.baseURL = BaseURLTestingWithSeal
 		} else {
-			c.client.SetBaseURL(BaseURLTesting)
+			c.baseURL = BaseURLTesting
 		}
 	}
-	// tlsConf.InsecureSkipVerify = true
-	c.client.SetTLSClientConfig(tlsConf)
-	c.client.SetDebug(os.Getenv("DEBUG") == "true")
 	return c, nil
 }
 
-func (c *connection) post(ctx context.Context, payload []byte) (*EnvelopeResponse, error) {
+// endpoint provides the URL to use for the operation.
+func (c *connection) endpoint(op Operation) string {
+	if u, ok := c.endpoints[op]; ok {
+		return u
+	}
+	return c.baseURL
+}
+
+func (c *connection) post(ctx context.Context, op Operation, payload []byte, nifs []string) (*EnvelopeResponse, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	ex := &Exchange{
+		ID:        correlationID(ctx),
+		Operation: op,
+		URL:       c.endpoint(op),
+		NIFs:      nifs,
+		Request:   payload,
+		Started:   time.Now(),
+	}
+	out, err := c.send(ctx, ex)
+	ex.Duration = time.Since(ex.Started)
+	ex.Err = err
+
+	if c.logger != nil {
+		logExchange(ctx, c.logger, ex, c.redact)
+	}
+	if c.audit != nil {
+		c.audit(ctx, ex)
+	}
+	return out, err
+}
+
+func (c *connection) send(ctx context.Context, ex *Exchange) (*EnvelopeResponse, error) {
 	out := new(EnvelopeResponse)
 	req := c.client.R().
 		SetContext(ctx).
 		SetHeader("Content-Type", "application/xml").
 		SetContentLength(true).
-		SetBody(payload).
+		SetBody(ex.Request).
 		SetResult(out)
 
-	res, err := req.Post("")
+	res, err := req.Post(ex.URL)
+	if res != nil {
+		ex.StatusCode = res.StatusCode()
+		ex.Response = res.Body()
+	}
 	if err != nil {
-		return nil, err
+		return nil, ErrConnection.WithMessage(err.Error()).WithCause(err)
 	}
 	if res.StatusCode() != http.StatusOK {
-		return nil, ErrValidation.WithCode(strconv.Itoa(res.StatusCode())).WithMessage(res.String())
+		// The AEAT responds to faults with a 500 status code, so try to
+		// decode the fault details before falling back to the status.
+		fr := new(EnvelopeResponse)
+		if err := xml.Unmarshal(res.Body(), fr); err == nil && fr.Body.Fault != nil {
+			return nil, faultError(fr.Body.Fault)
+		}
+		// The body is only kept in the exchange, as it may contain
+		// identifiers that should not end up in logs.
+		return nil, ErrValidation.WithCode(strconv.Itoa(res.StatusCode())).WithMessage(http.StatusText(res.StatusCode()))
 	}
 	if out.Body.Fault != nil {
-		return nil, ErrValidation.WithMessage(out.Body.Fault.Message).WithCode(out.Body.Fault.Code)
+		return nil, faultError(out.Body.Fault)
 	}
 
 	return out, nil
 }
+
+func faultError(f *Fault) error {
+	return ErrValidation.WithMessage(f.Message).WithCode(f.Code)
+}
+
+// correlationID provides the ID defined in the context, or a new one.
+func correlationID(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIDKey{}).(string); ok && id != "" {
+		return id
+	}
+	return uuid.V7().String()
+}
@@ -0,0 +1,142 @@
+package verifactu_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingTransport struct {
+	urls []string
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.urls = append(t.urls, req.URL.String())
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+var testSoftware = verifactu.Software{
+	NombreRazon:                 "My Software",
+	NIF:                         "12345678A",
+	NombreSistemaInformatico:    "My Software",
+	IdSistemaInformatico:        "A1",
+	Version:                     "1.0",
+	NumeroInstalacion:           "12345678A",
+	TipoUsoPosibleSoloVerifactu: "S",
+	TipoUsoPosibleMultiOT:       "S",
+	IndicadorMultiplesOT:        "N",
+}
+
+func TestClientConnection(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	env := test.LoadEnvelope("inv-base.json")
+
+	newRequest := func(t *testing.T, c *verifactu.Client) *verifactu.InvoiceRequest {
+		t.Helper()
+		ir, err := c.NewEnvelopeInvoiceRequest(env, nil)
+		require.NoError(t, err)
+		return ir
+	}
+
+	t.Run("no connection", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		_, err = c.SendInvoiceRequest(context.Background(), newRequest(t, c))
+		assert.ErrorIs(t, err, verifactu.ErrConnection)
+	})
+
+	t.Run("with base URL", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBaseURL(srv.URL),
+		)
+		require.NoError(t, err)
+		res, err := c.SendInvoiceRequest(context.Background(), newRequest(t, c))
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.SubmissionCorrect, res.Status)
+		assert.Len(t, srv.Records("B85905495"), 1)
+	})
+
+	t.Run("with fault", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+		srv.FailNext(&verifactu.Fault{Code: "env:Server", Message: "Codigo[999].Servicio no disponible"})
+
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBaseURL(srv.URL),
+		)
+		require.NoError(t, err)
+		_, err = c.SendInvoiceRequest(context.Background(), newRequest(t, c))
+		require.ErrorIs(t, err, verifactu.ErrValidation)
+		var verr *verifactu.Error
+		require.ErrorAs(t, err, &verr)
+		assert.Equal(t, "env:Server", verr.Code())
+		assert.Equal(t, "Codigo[999].Servicio no disponible", verr.Message())
+	})
+
+	t.Run("with endpoint and transport", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+
+		tr := new(countingTransport)
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBaseURL("http://invalid.example.com"),
+			verifactu.WithEndpoint(verifactu.OperationRegister, srv.URL+"/register"),
+			verifactu.WithTransport(tr),
+		)
+		require.NoError(t, err)
+		_, err = c.SendInvoiceRequest(context.Background(), newRequest(t, c))
+		require.NoError(t, err)
+		assert.Equal(t, []string{srv.URL + "/register"}, tr.urls)
+	})
+
+	t.Run("with HTTP client", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+
+		tr := new(countingTransport)
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBaseURL(srv.URL),
+			verifactu.WithHTTPClient(&http.Client{Transport: tr}),
+		)
+		require.NoError(t, err)
+		_, err = c.SendInvoiceRequest(context.Background(), newRequest(t, c))
+		require.NoError(t, err)
+		assert.Len(t, tr.urls, 1)
+	})
+
+	t.Run("with timeout", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(200 * time.Millisecond):
+			}
+		}))
+		defer srv.Close()
+
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithBaseURL(srv.URL),
+			verifactu.WithTimeout(10*time.Millisecond),
+		)
+		require.NoError(t, err)
+		_, err = c.SendInvoiceRequest(context.Background(), newRequest(t, c))
+		assert.ErrorIs(t, err, verifactu.ErrConnection)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
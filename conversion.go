@@ -0,0 +1,117 @@
+package verifactu
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+)
+
+// prepareInvoice provides the version of the invoice used to build the
+// registration. Credit notes are inverted and amounts converted to euros on a
+// copy, so that the original invoice is never modified.
+func prepareInvoice(inv *bill.Invoice) (*bill.Invoice, error) {
+	credit := inv.Type == bill.InvoiceTypeCreditNote
+	if !credit && (inv.Currency == "" || inv.Currency == currency.EUR) {
+		return inv, nil
+	}
+	out, err := copyInvoice(inv)
+	if err != nil {
+		return nil, err
+	}
+	if credit {
+		// In VeriFactu credit notes become "facturas rectificativas por diferencias",
+		// which require negative totals.
+		if err := out.Invert(); err != nil {
+			return nil, err
+		}
+	}
+	return convertToEUR(out)
+}
+
+// copyInvoice provides a deep copy of the invoice.
+func copyInvoice(inv *bill.Invoice) (*bill.Invoice, error) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return nil, fmt.Errorf("copying invoice: %w", err)
+	}
+	out := new(bill.Invoice)
+	if err := json.Unmarshal(data, out); err != nil {
+		return nil, fmt.Errorf("copying invoice: %w", err)
+	}
+	return out, nil
+}
+
+// convertToEUR provides a version of the invoice with all the amounts in euros,
+// as required by the AEAT, using the exchange rates defined in the invoice.
+// Line amounts are converted first and the totals recalculated, so that the
+// breakdown will always add up. Invoices already in euros are returned as is.
+func convertToEUR(inv *bill.Invoice) (*bill.Invoice, error) {
+	if inv.Currency == "" || inv.Currency == currency.EUR {
+		return inv, nil
+	}
+	ex := currency.MatchExchangeRate(inv.ExchangeRates, inv.Currency, currency.EUR)
+	if ex == nil {
+		return nil, ErrValidation.WithMessage(
+			fmt.Sprintf("missing exchange rate from %s to EUR", inv.Currency),
+		)
+	}
+	out, err := inv.ConvertInto(currency.EUR)
+	if err != nil {
+		return nil, ErrValidation.WithMessage(fmt.Sprintf("converting to EUR: %s", err)).WithCause(err)
+	}
+
+	// Preceding document totals are not converted by GOBL. They are assumed
+	// to be in the same currency as the invoice.
+	if len(inv.Preceding) > 0 {
+		out.Preceding = make([]*org.DocumentRef, len(inv.Preceding))
+		for i, ref := range inv.Preceding {
+			r := *ref
+			r.Tax = convertTaxTotal(ex, ref.Tax)
+			out.Preceding[i] = &r
+		}
+	}
+	return out, nil
+}
+
+func convertTaxTotal(ex *currency.ExchangeRate, t *tax.Total) *tax.Total {
+	if t == nil {
+		return nil
+	}
+	out := &tax.Total{
+		Categories: make([]*tax.CategoryTotal, len(t.Categories)),
+		Sum:        ex.Convert(t.Sum),
+		Retained:   convertAmount(ex, t.Retained),
+	}
+	for i, cat := range t.Categories {
+		c := *cat
+		c.Amount = ex.Convert(cat.Amount)
+		c.Surcharge = convertAmount(ex, cat.Surcharge)
+		c.Rates = make([]*tax.RateTotal, len(cat.Rates))
+		for j, rate := range cat.Rates {
+			r := *rate
+			r.Base = ex.Convert(rate.Base)
+			r.Amount = ex.Convert(rate.Amount)
+			if rate.Surcharge != nil {
+				s := *rate.Surcharge
+				s.Amount = ex.Convert(rate.Surcharge.Amount)
+				r.Surcharge = &s
+			}
+			c.Rates[j] = &r
+		}
+		out.Categories[i] = &c
+	}
+	return out
+}
+
+func convertAmount(ex *currency.ExchangeRate, a *num.Amount) *num.Amount {
+	if a == nil {
+		return nil
+	}
+	c := ex.Convert(*a)
+	return &c
+}
@@ -0,0 +1,85 @@
+package verifactu
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/cbc"
+)
+
+// Diagnostic keys
+const (
+	// DiagnosticDescription is used when the DescripcionOperacion was inferred
+	// from the invoice lines as no general note was provided.
+	DiagnosticDescription cbc.Key = "description"
+	// DiagnosticCreditNote is used when the invoice amounts were inverted
+	// because the document is a credit note.
+	DiagnosticCreditNote cbc.Key = "credit-note"
+	// DiagnosticCurrency is used when the invoice amounts were converted into
+	// euros.
+	DiagnosticCurrency cbc.Key = "currency"
+	// DiagnosticChargeRemoved is used when an untaxed charge was removed from
+	// the ImporteTotal.
+	DiagnosticChargeRemoved cbc.Key = "charge-removed"
+	// DiagnosticPartialBreakdown is used when an untaxed charge was kept in
+	// the ImporteTotal because the regime only declares part of the operation.
+	DiagnosticPartialBreakdown cbc.Key = "partial-breakdown"
+	// DiagnosticCustomerDropped is used when the customer could not be
+	// included as a recipient as no usable identity was found.
+	DiagnosticCustomerDropped cbc.Key = "customer-dropped"
+	// DiagnosticMacrodato is used when the Macrodato flag was set because of
+	// the invoice's total amount.
+	DiagnosticMacrodato cbc.Key = "macrodato"
+	// DiagnosticNormalized is used when a text value was changed to comply
+	// with the AEAT formats.
+	DiagnosticNormalized cbc.Key = "normalized"
+)
+
+// Diagnostic explains a difference between the data in the invoice and the
+// data declared to the AEAT.
+type Diagnostic struct {
+	// Key identifies the type of diagnostic.
+	Key cbc.Key `json:"key"`
+	// Field is the name of the registration's XML element affected.
+	Field string `json:"field,omitempty"`
+	// Message describes what happened.
+	Message string `json:"message"`
+}
+
+// String provides a human readable version of the diagnostic.
+func (d *Diagnostic) String() string {
+	if d.Field == "" {
+		return fmt.Sprintf("%s: %s", d.Key, d.Message)
+	}
+	return fmt.Sprintf("%s (%s): %s", d.Key, d.Field, d.Message)
+}
+
+// diagnose adds a diagnostic to the registration.
+func (r *InvoiceRegistration) diagnose(key cbc.Key, field, msg string, args ...any) {
+	r.diagnostics = append(r.diagnostics, &Diagnostic{
+		Key:     key,
+		Field:   field,
+		Message: fmt.Sprintf(msg, args...),
+	})
+}
+
+// Preview builds the registration for the invoice in the envelope, in the same
+// way as RegisterInvoice, but without signing the record or adding stamps to
+// the envelope. Diagnostics are provided to explain why the amounts and details
+// declared may differ from those in the invoice.
+func (c *Client) Preview(env *gobl.Envelope, prev *ChainData, opts ...GenerateOption) (*InvoiceRegistration, []*Diagnostic, error) {
+	reg, err := c.buildRegistration(env, prev, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	diags := make([]*Diagnostic, 0, len(reg.diagnostics)+len(reg.warnings))
+	diags = append(diags, reg.diagnostics...)
+	for _, w := range reg.warnings {
+		diags = append(diags, &Diagnostic{
+			Key:     DiagnosticNormalized,
+			Field:   w.Field,
+			Message: w.Message,
+		})
+	}
+	return reg, diags, nil
+}
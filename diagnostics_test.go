@@ -0,0 +1,92 @@
+package verifactu_test
+
+import (
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	c, err := verifactu.New(testSoftware,
+		verifactu.WithCurrentTime(ts),
+		verifactu.WithCertificate(test.Certificate(t)),
+		verifactu.WithSigning(),
+	)
+	require.NoError(t, err)
+
+	keys := func(diags []*verifactu.Diagnostic) []cbc.Key {
+		out := make([]cbc.Key, len(diags))
+		for i, d := range diags {
+			out[i] = d.Key
+		}
+		return out
+	}
+
+	t.Run("no stamps or signature", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		reg, diags, err := c.Preview(env, nil)
+		require.NoError(t, err)
+		assert.Empty(t, env.Head.Stamps)
+		assert.Nil(t, reg.Signature)
+		assert.NotEmpty(t, reg.Huella)
+		assert.Empty(t, diags)
+	})
+
+	t.Run("credit note and currency", func(t *testing.T) {
+		_, diags, err := c.Preview(test.LoadEnvelope("cred-note-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []cbc.Key{verifactu.DiagnosticCreditNote}, keys(diags))
+
+		_, diags, err = c.Preview(test.LoadEnvelope("export-us-usd.json"), nil)
+		require.NoError(t, err)
+		require.Equal(t, []cbc.Key{verifactu.DiagnosticDescription, verifactu.DiagnosticCurrency}, keys(diags))
+		assert.Equal(t, "currency (ImporteTotal): converted from USD to EUR at 0.9236", diags[1].String())
+	})
+
+	t.Run("charges", func(t *testing.T) {
+		_, diags, err := c.Preview(test.LoadEnvelope("inv-base-outlay.json"), nil)
+		require.NoError(t, err)
+		require.Equal(t, []cbc.Key{verifactu.DiagnosticChargeRemoved}, keys(diags))
+		assert.Contains(t, diags[0].Message, "100.00")
+
+		_, diags, err = c.Preview(test.LoadEnvelope("inv-rebu.json"), nil)
+		require.NoError(t, err)
+		require.Equal(t, []cbc.Key{verifactu.DiagnosticPartialBreakdown}, keys(diags))
+		assert.Contains(t, diags[0].Message, "9.00")
+	})
+
+	t.Run("description, customer and normalisation", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		inv.Notes = nil
+		inv.Supplier.Name = "Invopop S.L. "
+		inv.Customer.TaxID = nil
+		inv.Customer.Identities = nil
+		reg, diags, err := c.Preview(env, nil)
+		require.NoError(t, err)
+		assert.Empty(t, reg.Destinatarios)
+		assert.Equal(t, []cbc.Key{
+			verifactu.DiagnosticDescription,
+			verifactu.DiagnosticCustomerDropped,
+			verifactu.DiagnosticNormalized,
+		}, keys(diags))
+		assert.Equal(t, "NombreRazonEmisor", diags[2].Field)
+	})
+
+	t.Run("macrodato", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		inv.Lines[0].Item.Price = num.NewAmount(10000000000, 2)
+		require.NoError(t, inv.Calculate())
+		reg, diags, err := c.Preview(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "S", reg.Macrodato)
+		assert.Equal(t, []cbc.Key{verifactu.DiagnosticMacrodato}, keys(diags))
+	})
+}
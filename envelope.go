@@ -15,22 +15,11 @@ const (
 	EnvNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
 )
 
-// for needed for timezones
-var location *time.Location
-
 const (
 	// CurrentVersion is the current version of the VeriFactu document
 	CurrentVersion = "1.0"
 )
 
-func init() {
-	var err error
-	location, err = time.LoadLocation("Europe/Madrid")
-	if err != nil {
-		panic(err)
-	}
-}
-
 // Envelope is the SOAP envelope wrapper used for sending messages to
 // the remote service.
 type Envelope struct {
@@ -39,9 +28,11 @@ type Envelope struct {
 	SUM     string   `xml:"xmlns:sum,attr,omitempty"`
 	SUM1    string   `xml:"xmlns:sum1,attr,omitempty"`
 	DS      string   `xml:"xmlns:ds,attr,omitempty"`
+	CON     string   `xml:"xmlns:con,attr,omitempty"`
 	Body    struct {
 		ID             string          `xml:"soapenv:Id,attr,omitempty"`
 		InvoiceRequest *InvoiceRequest `xml:"sum:RegFactuSistemaFacturacion,omitempty"`
+		InvoiceQuery   *InvoiceQuery   `xml:"con:ConsultaFactuSistemaFacturacion,omitempty"`
 	} `xml:"soapenv:Body"`
 }
 
@@ -50,9 +41,10 @@ type Envelope struct {
 type EnvelopeResponse struct {
 	XMLName xml.Name `xml:"Envelope"`
 	Body    struct {
-		ID              string           `xml:"Id,attr,omitempty"`
-		Fault           *Fault           `xml:"Fault,omitempty"`
-		InvoiceResponse *InvoiceResponse `xml:"RespuestaRegFactuSistemaFacturacion,omitempty"`
+		ID              string                `xml:"Id,attr,omitempty"`
+		Fault           *Fault                `xml:"Fault,omitempty"`
+		InvoiceResponse *InvoiceResponse      `xml:"RespuestaRegFactuSistemaFacturacion,omitempty"`
+		QueryResponse   *InvoiceQueryResponse `xml:"RespuestaConsultaFactuSistemaFacturacion,omitempty"`
 	} `xml:"Body"`
 }
 
@@ -72,6 +64,14 @@ func newEnvelope() *Envelope {
 	return env
 }
 
+func newQueryEnvelope() *Envelope {
+	return &Envelope{
+		XMLNs: EnvNamespace,
+		SUM1:  SUM1,
+		CON:   CON,
+	}
+}
+
 // Bytes returns the XML document bytes
 func (d *Envelope) Bytes() ([]byte, error) {
 	return toBytes(d)
@@ -115,10 +115,8 @@ func buffer(doc any, base string, indent bool) (*bytes.Buffer, error) {
 	return buf, nil
 }
 
-type timeLocationable interface {
-	In(*time.Location) time.Time
-}
-
-func formatDateTimeZone(ts timeLocationable) string {
-	return ts.In(location).Format("2006-01-02T15:04:05-07:00")
+// formatDateTimeZone formats the timestamp with the offset of its location,
+// which is included in the record's fingerprint.
+func formatDateTimeZone(ts time.Time) string {
+	return ts.Format("2006-01-02T15:04:05-07:00")
 }
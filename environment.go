@@ -0,0 +1,118 @@
+package verifactu
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/addons/es/verifactu"
+)
+
+// Environment returns the environment the registration was generated in, or
+// an empty string if unknown, such as for records loaded from storage.
+func (r *InvoiceRegistration) Environment() Environment {
+	return r.env
+}
+
+// Environment returns the environment the cancellation was generated in, or
+// an empty string if unknown.
+func (c *InvoiceCancellation) Environment() Environment {
+	return c.env
+}
+
+// Environment returns the environment the event was generated in, or an
+// empty string if unknown.
+func (r *EventRegistration) Environment() Environment {
+	return r.env
+}
+
+// SetEnvironment restores the environment of a registration loaded from
+// storage, such as the one provided by EnvelopeEnvironment, so that it can
+// be checked before sending.
+func (r *InvoiceRegistration) SetEnvironment(env Environment) {
+	r.env = env
+}
+
+// SetEnvironment restores the environment of a cancellation loaded from
+// storage.
+func (c *InvoiceCancellation) SetEnvironment(env Environment) {
+	c.env = env
+}
+
+// SetEnvironment restores the environment of an event loaded from storage.
+func (r *EventRegistration) SetEnvironment(env Environment) {
+	r.env = env
+}
+
+// EnvelopeEnvironment provides the environment of the latest record generated
+// for the invoice in the envelope, using the stamp added when the record was
+// generated or, for envelopes stamped before it was available, the address
+// of the QR code. An empty string is returned if unknown.
+func EnvelopeEnvironment(env *gobl.Envelope) Environment {
+	if st := env.Head.GetStamp(StampKeyEnvironment); st != nil {
+		return Environment(st.Value)
+	}
+	if st := env.Head.GetStamp(verifactu.StampQR); st != nil {
+		if d, err := ParseQRURL(st.Value); err == nil {
+			return d.Environment
+		}
+	}
+	return ""
+}
+
+// Environment returns the environment the request was prepared for, or an
+// empty string if unknown.
+func (req *InvoiceRequest) Environment() Environment {
+	return req.env
+}
+
+// SetEnvironment restores the environment of a request that was not prepared
+// by NewInvoiceRequest, such as one loaded from storage.
+func (req *InvoiceRequest) SetEnvironment(env Environment) {
+	req.env = env
+}
+
+// Environment returns the environment of the record in this line.
+func (line *InvoiceRequestLine) Environment() Environment {
+	if r := line.Registration; r != nil {
+		return r.env
+	}
+	if r := line.Cancellation; r != nil {
+		return r.env
+	}
+	return ""
+}
+
+// checkEnvironment ensures records and chain data generated in one
+// environment are never used with a client connected to the other, so that
+// sandbox chains cannot leak into production. Unknown environments are
+// accepted, see checkKnownEnvironment for the stricter version.
+func (c *Client) checkEnvironment(env Environment, what string) error {
+	if env == "" || env == c.env {
+		return nil
+	}
+	return ErrEnvironment.WithMessage(fmt.Sprintf("%s generated in %s environment, client uses %s", what, env, c.env))
+}
+
+// checkRequestEnvironment checks the environment of the request and each of
+// its lines before sending.
+func (c *Client) checkRequestEnvironment(ir *InvoiceRequest) error {
+	if err := c.checkKnownEnvironment(ir.env, "request"); err != nil {
+		return err
+	}
+	for _, line := range ir.Lines {
+		if err := c.checkKnownEnvironment(line.Environment(), "record"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKnownEnvironment is like checkEnvironment, but production clients also
+// refuse unknown environments, as the data may have been generated in the
+// sandbox. Used for records being sent and previous chain data.
+func (c *Client) checkKnownEnvironment(env Environment, what string) error {
+	if env == "" && c.env == EnvironmentProduction {
+		return ErrEnvironment.WithMessage(fmt.Sprintf("%s with unknown environment, production clients require it to be set", what))
+	}
+	return c.checkEnvironment(env, what)
+}
@@ -0,0 +1,178 @@
+package verifactu_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	srv := verifactutest.NewServer()
+	t.Cleanup(srv.Close)
+	sandbox, err := verifactu.New(testSoftware,
+		verifactu.WithCurrentTime(ts),
+		verifactu.WithBaseURL(srv.URL),
+	)
+	require.NoError(t, err)
+	prod, err := verifactu.New(testSoftware,
+		verifactu.WithCurrentTime(ts),
+		verifactu.WithBaseURL(srv.URL),
+		verifactu.InProduction(),
+	)
+	require.NoError(t, err)
+
+	t.Run("records are tagged", func(t *testing.T) {
+		reg, err := sandbox.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.EnvironmentSandbox, reg.Environment())
+		assert.Equal(t, verifactu.EnvironmentSandbox, reg.ChainData().Environment)
+
+		can, err := prod.CancelInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.EnvironmentProduction, can.Environment())
+		assert.Equal(t, verifactu.EnvironmentProduction, can.ChainData().Environment)
+
+		evt, err := sandbox.RegisterEvent(test.LoadEnvelope("status-system-startup.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.EnvironmentSandbox, evt.Environment())
+		assert.Equal(t, verifactu.EnvironmentSandbox, evt.ChainData().Environment)
+	})
+
+	t.Run("chain data round trip", func(t *testing.T) {
+		reg, err := sandbox.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		data, err := json.Marshal(reg.ChainData())
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"environment":"sandbox"`)
+
+		prev := new(verifactu.ChainData)
+		require.NoError(t, json.Unmarshal(data, prev))
+		_, err = prod.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), prev)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		_, err = prod.CancelInvoice(test.LoadEnvelope("inv-base.json"), prev)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		_, err = sandbox.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), prev)
+		assert.NoError(t, err)
+	})
+
+	t.Run("untagged chain data", func(t *testing.T) {
+		reg, err := sandbox.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		prev := reg.ChainData()
+		prev.Environment = ""
+		_, err = prod.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), prev)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		assert.ErrorContains(t, err, "previous record with unknown environment")
+		_, err = prod.CancelInvoice(test.LoadEnvelope("inv-base.json"), prev)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		_, err = sandbox.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), prev)
+		assert.NoError(t, err)
+
+		evt, err := sandbox.RegisterEvent(test.LoadEnvelope("status-system-startup.json"), nil)
+		require.NoError(t, err)
+		eprev := evt.ChainData()
+		eprev.Environment = ""
+		_, err = prod.RegisterEvent(test.LoadEnvelope("status-system-shutdown.json"), eprev)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+	})
+
+	t.Run("event chain", func(t *testing.T) {
+		evt, err := sandbox.RegisterEvent(test.LoadEnvelope("status-system-startup.json"), nil)
+		require.NoError(t, err)
+		_, err = prod.RegisterEvent(test.LoadEnvelope("status-system-shutdown.json"), evt.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+	})
+
+	t.Run("send", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		reg, err := sandbox.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+
+		// records from another environment
+		ir, err := prod.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg)
+		_, err = prod.SendInvoiceRequest(context.Background(), ir)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		assert.ErrorContains(t, err, "record generated in sandbox environment, client uses production")
+
+		// request from another environment
+		ir, err = sandbox.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg)
+		_, err = prod.SendInvoiceRequest(context.Background(), ir)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+
+		_, err = sandbox.SendInvoiceRequest(context.Background(), ir)
+		assert.NoError(t, err)
+	})
+
+	t.Run("envelope stamps", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		assert.Empty(t, verifactu.EnvelopeEnvironment(env))
+		_, err := sandbox.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.EnvironmentSandbox, verifactu.EnvelopeEnvironment(env))
+
+		_, err = prod.RegisterInvoice(env, nil)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		assert.ErrorContains(t, err, "envelope generated in sandbox environment, client uses production")
+		_, err = prod.CancelInvoice(env, nil)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+
+		// envelopes stamped before the environment stamp use the QR code
+		st := env.Head.GetStamp(verifactu.StampKeyEnvironment)
+		require.NotNil(t, st)
+		st.Provider = "other"
+		assert.Equal(t, verifactu.EnvironmentSandbox, verifactu.EnvelopeEnvironment(env))
+		_, err = prod.RegisterInvoice(env, nil)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+	})
+
+	t.Run("stored records", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		reg, err := sandbox.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		// a record loaded from storage has no environment
+		loaded := *reg
+		loaded.SetEnvironment("")
+		ir, err := prod.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(&loaded)
+		_, err = prod.SendInvoiceRequest(context.Background(), ir)
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+		assert.ErrorContains(t, err, "record with unknown environment")
+
+		loaded.SetEnvironment(verifactu.EnvelopeEnvironment(env))
+		assert.Equal(t, verifactu.EnvironmentSandbox, loaded.Environment())
+		_, err = prod.SendInvoiceRequest(context.Background(), ir)
+		assert.ErrorContains(t, err, "record generated in sandbox environment")
+
+		// requests not prepared by the client
+		loaded.SetEnvironment(verifactu.EnvironmentProduction)
+		ir = &verifactu.InvoiceRequest{Header: ir.Header}
+		ir.AddRegistration(&loaded)
+		_, err = prod.SendInvoiceRequest(context.Background(), ir)
+		assert.ErrorContains(t, err, "request with unknown environment")
+		ir.SetEnvironment(verifactu.EnvironmentProduction)
+		assert.Equal(t, verifactu.EnvironmentProduction, ir.Environment())
+		_, err = prod.SendInvoiceRequest(context.Background(), ir)
+		assert.NotErrorIs(t, err, verifactu.ErrEnvironment)
+
+		loaded.SetEnvironment("")
+		ir, err = sandbox.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(&loaded)
+		_, err = sandbox.SendInvoiceRequest(context.Background(), ir)
+		assert.NotErrorIs(t, err, verifactu.ErrEnvironment)
+	})
+}
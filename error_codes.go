@@ -0,0 +1,126 @@
+package verifactu
+
+import (
+	_ "embed"
+	"encoding/json"
+	"regexp"
+	"strconv"
+
+	"github.com/invopop/gobl/i18n"
+)
+
+// ErrorCategory groups the AEAT error codes by their effect.
+type ErrorCategory string
+
+// Error categories
+const (
+	// CategorySubmission errors reject the complete request.
+	CategorySubmission ErrorCategory = "submission"
+	// CategoryRejection errors reject an individual record.
+	CategoryRejection ErrorCategory = "rejection"
+	// CategoryWarning errors are raised when a record was accepted with errors.
+	CategoryWarning ErrorCategory = "warning"
+	// CategoryRecord errors are caused by the state of a record previously
+	// registered with the AEAT, such as duplicates.
+	CategoryRecord ErrorCategory = "record"
+)
+
+// Remedy describes how an error may be resolved.
+type Remedy string
+
+// Remedies
+const (
+	// RemedyAmend errors are resolved by sending a correction of the record,
+	// known as a "Subsanación".
+	RemedyAmend Remedy = "amend"
+	// RemedyResend errors are resolved by fixing the data and sending the
+	// record again.
+	RemedyResend Remedy = "resend"
+	// RemedyConfigure errors are caused by the certificate, census or
+	// representation permissions of the submitter, and will be raised again
+	// until they are fixed outside of the record.
+	RemedyConfigure Remedy = "configure"
+	// RemedyNone errors cannot be resolved by sending the record again.
+	RemedyNone Remedy = "none"
+)
+
+// ErrorCode describes one of the error codes published by the AEAT in the
+// list of validations and errors of the VeriFactu service.
+type ErrorCode struct {
+	Code        string        `json:"code"`
+	Category    ErrorCategory `json:"category"`
+	Remedy      Remedy        `json:"remedy"`
+	Description i18n.String   `json:"description"`
+}
+
+//go:embed data/error_codes.json
+var errorCodesData []byte
+
+var errorCodes map[string]*ErrorCode
+
+func init() {
+	var list []*ErrorCode
+	if err := json.Unmarshal(errorCodesData, &list); err != nil {
+		panic(err)
+	}
+	errorCodes = make(map[string]*ErrorCode, len(list))
+	for _, ec := range list {
+		errorCodes[ec.Code] = ec
+	}
+}
+
+// codeRegexp finds the codes included inside SOAP fault messages.
+var codeRegexp = regexp.MustCompile(`Codigo\[(\d+)\]`)
+
+// LookupErrorCode provides the details of the AEAT error code. Codes in the
+// published 1000 to 4999 ranges that are not found in the catalogue will be
+// described using the defaults for their range. Nil is returned for any other
+// code, such as HTTP status codes or SOAP fault codes.
+func LookupErrorCode(code string) *ErrorCode {
+	if ec, ok := errorCodes[code]; ok {
+		return ec
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 1000 || n > 4999 {
+		return nil
+	}
+	ec := &ErrorCode{
+		Code: code,
+		Description: i18n.String{
+			i18n.ES: "Código de error desconocido.",
+			i18n.EN: "Unknown error code.",
+		},
+	}
+	switch {
+	case n >= 4000:
+		ec.Category = CategorySubmission
+		ec.Remedy = RemedyResend
+	case n >= 3000:
+		ec.Category = CategoryRecord
+		ec.Remedy = RemedyNone
+	case n >= 2000:
+		ec.Category = CategoryWarning
+		ec.Remedy = RemedyAmend
+	default:
+		ec.Category = CategoryRejection
+		ec.Remedy = RemedyResend
+	}
+	return ec
+}
+
+// Details provides the catalogue entry for the error's code. SOAP faults do
+// not include the AEAT code in the fault code, so the message will be checked
+// too. Nil is returned for connection errors, or when no AEAT code is
+// available.
+func (e *Error) Details() *ErrorCode {
+	if e.key == ErrConnection.key {
+		return nil
+	}
+	if ec := LookupErrorCode(e.code); ec != nil {
+		return ec
+	}
+	if m := codeRegexp.FindStringSubmatch(e.message); m != nil {
+		return LookupErrorCode(m[1])
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package verifactu_test
+
+import (
+	"testing"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl/i18n"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupErrorCode(t *testing.T) {
+	t.Run("known codes", func(t *testing.T) {
+		ec := verifactu.LookupErrorCode("3000")
+		require.NotNil(t, ec)
+		assert.Equal(t, verifactu.CategoryRecord, ec.Category)
+		assert.Equal(t, verifactu.RemedyNone, ec.Remedy)
+		assert.Equal(t, "Registro de facturación duplicado.", ec.Description.In(i18n.ES))
+		assert.Equal(t, "Duplicated invoice record.", ec.Description.In(i18n.EN))
+
+		ec = verifactu.LookupErrorCode("2000")
+		require.NotNil(t, ec)
+		assert.Equal(t, verifactu.CategoryWarning, ec.Category)
+		assert.Equal(t, verifactu.RemedyAmend, ec.Remedy)
+
+		ec = verifactu.LookupErrorCode("1100")
+		require.NotNil(t, ec)
+		assert.Equal(t, verifactu.CategoryRejection, ec.Category)
+		assert.Equal(t, verifactu.RemedyResend, ec.Remedy)
+
+		ec = verifactu.LookupErrorCode("4102")
+		require.NotNil(t, ec)
+		assert.Equal(t, verifactu.CategorySubmission, ec.Category)
+
+		ec = verifactu.LookupErrorCode("1246")
+		require.NotNil(t, ec)
+		assert.Equal(t, "El valor del campo ClaveRegimen es incorrecto.", ec.Description.In(i18n.ES))
+	})
+
+	t.Run("permission codes", func(t *testing.T) {
+		for _, code := range []string{"4104", "4107", "4112", "3003"} {
+			ec := verifactu.LookupErrorCode(code)
+			require.NotNil(t, ec, code)
+			assert.Equal(t, verifactu.RemedyConfigure, ec.Remedy, code)
+		}
+	})
+
+	t.Run("unknown codes", func(t *testing.T) {
+		ec := verifactu.LookupErrorCode("2999")
+		require.NotNil(t, ec)
+		assert.Equal(t, "2999", ec.Code)
+		assert.Equal(t, verifactu.CategoryWarning, ec.Category)
+		assert.Equal(t, verifactu.RemedyAmend, ec.Remedy)
+		assert.NotEmpty(t, ec.Description.In(i18n.ES))
+
+		assert.Nil(t, verifactu.LookupErrorCode(""))
+		assert.Nil(t, verifactu.LookupErrorCode("env:Client"))
+		assert.Nil(t, verifactu.LookupErrorCode("500"))
+		assert.Nil(t, verifactu.LookupErrorCode("403"))
+		assert.Nil(t, verifactu.LookupErrorCode("5000"))
+	})
+
+	t.Run("error details", func(t *testing.T) {
+		line := responseLine(verifactu.OpTypeRegistration, "B85905495", "A-1", "13-11-2024", verifactu.StatusIncorrect, "1189")
+		var err *verifactu.Error
+		require.ErrorAs(t, line.Error(), &err)
+		require.NotNil(t, err.Details())
+		assert.Equal(t, verifactu.CategoryRejection, err.Details().Category)
+
+		err = verifactu.ErrValidation.WithCode("env:Client").WithMessage("Codigo[4102].El XML no cumple el esquema.")
+		require.NotNil(t, err.Details())
+		assert.Equal(t, "4102", err.Details().Code)
+
+		assert.Nil(t, verifactu.ErrValidation.Details())
+		assert.Nil(t, verifactu.ErrValidation.WithCode("500").WithMessage("Internal Server Error").Details())
+		assert.Nil(t, verifactu.ErrConnection.WithMessage("Codigo[4102] in a proxy page").Details())
+	})
+}
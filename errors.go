@@ -11,6 +11,19 @@ var (
 	ErrValidation = newError("validation")
 	ErrDuplicate  = newError("duplicate")
 	ErrWarning    = newError("warning")
+	ErrMissing    = newError("missing")
+
+	// ErrNotRemediable is returned when a record cannot be fixed by sending a
+	// follow-up record.
+	ErrNotRemediable = newError("not-remediable")
+
+	// ErrDateAnomaly is returned when a record would be timestamped before the
+	// previous record in the chain.
+	ErrDateAnomaly = newError("date-anomaly")
+
+	// ErrEnvironment is returned when records or chain data generated in one
+	// environment are used with a client configured for another.
+	ErrEnvironment = newError("environment")
 )
 
 // Standard error responses.
@@ -75,6 +88,13 @@ func (e *Error) WithMessage(msg string) *Error {
 	return e
 }
 
+// WithCause duplicates and adds the underlying cause to the error.
+func (e *Error) WithCause(err error) *Error {
+	e = e.clone()
+	e.cause = err
+	return e
+}
+
 func (e *Error) clone() *Error {
 	ne := new(Error)
 	*ne = *e
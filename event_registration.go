@@ -39,6 +39,8 @@ type EventRegistration struct {
 	SF      string   `xml:"xmlns:sf,attr,omitempty"`
 	Version string   `xml:"sf:IDVersion"`
 	Event   *Event   `xml:"sf:Evento"`
+
+	env Environment
 }
 
 // Event contains the event data (EventoType).
@@ -468,7 +470,9 @@ func (e *Event) ChainData() *EventChainData {
 // ChainData returns the chaining data from the inner event.
 func (r *EventRegistration) ChainData() *EventChainData {
 	if r.Event != nil {
-		return r.Event.ChainData()
+		cd := r.Event.ChainData()
+		cd.Environment = r.env
+		return cd
 	}
 	return nil
 }
@@ -1,9 +1,13 @@
 package verifactu
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/invopop/gobl/addons/es/verifactu"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/xmldsig"
 	"github.com/nbio/xml"
 )
@@ -25,8 +29,24 @@ type InvoiceCancellation struct {
 	TipoHuella               string             `xml:"sum1:TipoHuella"`
 	Huella                   string             `xml:"sum1:Huella"`
 	Signature                *xmldsig.Signature `xml:"ds:Signature,omitempty"`
+
+	env      Environment
+	warnings []*Warning
+}
+
+// Warnings lists the changes made to the invoice data while building the
+// cancellation so that it would be accepted by the AEAT.
+func (c *InvoiceCancellation) Warnings() []*Warning {
+	return c.warnings
 }
 
+// Codes used in the GeneradoPor field of cancellations.
+const (
+	GeneratedByCodeIssuer     cbc.Code = "E"
+	GeneratedByCodeRecipient  cbc.Code = "D"
+	GeneratedByCodeThirdParty cbc.Code = "T"
+)
+
 // IDFacturaAnulada contains the identifying information for an invoice
 type IDFacturaAnulada struct {
 	IDEmisorFactura        string `xml:"sum1:IDEmisorFacturaAnulada"`
@@ -51,6 +71,45 @@ func newInvoiceCancellation(inv *bill.Invoice, ts time.Time, s *Software) *Invoi
 	return reg
 }
 
+// setGenerator determines who generated the cancellation using the options
+// provided, or the invoice's issuer type when none were given, so that the
+// cancellation is generated by the same party as the invoice.
+func (c *InvoiceCancellation) setGenerator(inv *bill.Invoice, o *generateOptions) error {
+	code := o.generatedBy
+	if code == cbc.CodeEmpty && inv.Tax != nil {
+		switch inv.Tax.Ext.Get(verifactu.ExtKeyIssuerType) {
+		case verifactu.ExtCodeIssuerTypeThirdParty:
+			code = GeneratedByCodeThirdParty
+		case verifactu.ExtCodeIssuerTypeCustomer:
+			code = GeneratedByCodeRecipient
+		}
+	}
+
+	var p *org.Party
+	switch code {
+	case cbc.CodeEmpty:
+		return nil
+	case GeneratedByCodeIssuer:
+		p = inv.Supplier
+	case GeneratedByCodeRecipient:
+		p = inv.Customer
+	case GeneratedByCodeThirdParty:
+		p = o.generator
+		if p == nil && inv.Ordering != nil {
+			p = inv.Ordering.Issuer
+		}
+	}
+
+	c.GeneradoPor = code.String()
+	c.Generador = newParty(p, inv.IssueDate)
+	if c.Generador == nil {
+		return ErrValidation.WithMessage(
+			fmt.Sprintf("Generador: missing tax ID or identity of party generating the cancellation (%s)", code),
+		)
+	}
+	return nil
+}
+
 // fingerprint will add a fingerprint to the cancellation message using the
 // ChainData from the last entry.
 func (c *InvoiceCancellation) fingerprint(prev *ChainData) {
@@ -87,6 +146,8 @@ func (c *InvoiceCancellation) ChainData() *ChainData {
 		NumSeries:   c.IDFactura.NumSerieFactura,
 		IssueDate:   c.IDFactura.FechaExpedicionFactura,
 		Fingerprint: c.Huella,
+		Timestamp:   c.FechaHoraHusoGenRegistro,
+		Environment: c.env,
 	}
 }
 
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,6 +28,59 @@ func TestNewRegistroAnulacion(t *testing.T) {
 		assert.Equal(t, "FR-012", ra.IDFactura.NumSerieFactura)
 		assert.Equal(t, "01-02-2022", ra.IDFactura.FechaExpedicionFactura)
 		assert.Equal(t, "01", ra.TipoHuella)
+		assert.Empty(t, ra.GeneradoPor)
+		assert.Nil(t, ra.Generador)
+	})
+
+	t.Run("generated by issuer", func(t *testing.T) {
+		env := test.LoadEnvelope("cred-note-base.json")
+		ra, err := vc.CancelInvoice(env, nil, GeneratedByIssuer())
+		require.NoError(t, err)
+		assert.Equal(t, "E", ra.GeneradoPor)
+		require.NotNil(t, ra.Generador)
+		assert.Equal(t, "B85905495", ra.Generador.NIF)
+	})
+
+	t.Run("generated by recipient", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		ra, err := vc.CancelInvoice(env, nil, GeneratedByRecipient())
+		require.NoError(t, err)
+		assert.Equal(t, "D", ra.GeneradoPor)
+		require.NotNil(t, ra.Generador)
+		assert.Equal(t, inv.Customer.Name, ra.Generador.NombreRazon)
+	})
+
+	t.Run("generated by third party from invoice", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-issuer.json")
+		ra, err := vc.CancelInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "T", ra.GeneradoPor)
+		require.NotNil(t, ra.Generador)
+		assert.Equal(t, "Amazon", ra.Generador.NombreRazon)
+		assert.Equal(t, "B63272603", ra.Generador.NIF)
+
+		data, err := ra.Bytes()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "<sum1:GeneradoPor>T</sum1:GeneradoPor>")
+	})
+
+	t.Run("generated by third party option", func(t *testing.T) {
+		env := test.LoadEnvelope("cred-note-base.json")
+		p := &org.Party{
+			Name:  "Gestoría Ejemplo S.L.",
+			TaxID: &tax.Identity{Country: "ES", Code: "B98602642"},
+		}
+		ra, err := vc.CancelInvoice(env, nil, GeneratedByThirdParty(p))
+		require.NoError(t, err)
+		assert.Equal(t, "T", ra.GeneradoPor)
+		assert.Equal(t, "B98602642", ra.Generador.NIF)
+	})
+
+	t.Run("generator without identity", func(t *testing.T) {
+		env := test.LoadEnvelope("cred-note-base.json")
+		_, err := vc.CancelInvoice(env, nil, GeneratedByThirdParty(nil))
+		assert.ErrorIs(t, err, ErrValidation)
+		assert.ErrorContains(t, err, "Generador")
 	})
 }
 
@@ -0,0 +1,204 @@
+package verifactu
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/invopop/gobl/org"
+	"github.com/nbio/xml"
+)
+
+// CON is the namespace of the query requests.
+const CON = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/ConsultaLR.xsd"
+
+// Record states reported by queries in the EstadoRegistro field. These differ
+// from the statuses of the submission response lines.
+const (
+	RecordStatusCorrect            string = "Correcta"
+	RecordStatusAcceptedWithErrors string = "AceptadaConErrores"
+	RecordStatusCancelled          string = "Anulada"
+)
+
+// InvoiceQuery is used to query the records registered by the AEAT for the
+// obligated party in a given period, as in ConsultaFactuSistemaFacturacion.
+// Pagination keys are not supported, so filters should be used to narrow
+// down the results.
+type InvoiceQuery struct {
+	XMLName xml.Name            `xml:"con:ConsultaFactuSistemaFacturacion"`
+	Header  *InvoiceQueryHeader `xml:"con:Cabecera"`
+	Filter  *InvoiceQueryFilter `xml:"con:FiltroConsulta"`
+}
+
+// InvoiceQueryHeader identifies the party whose records are queried.
+type InvoiceQueryHeader struct {
+	IDVersion string `xml:"sum1:IDVersion"`
+	Obligado  Issuer `xml:"sum1:ObligadoEmision"`
+}
+
+// InvoiceQueryFilter limits the records returned by the query. The period
+// is required, and the external reference is the preferred way to find a
+// specific record.
+type InvoiceQueryFilter struct {
+	Periodo         InvoiceQueryPeriod `xml:"con:PeriodoImputacion"`
+	NumSerieFactura string             `xml:"con:NumSerieFactura,omitempty"`
+	RefExterna      string             `xml:"con:RefExterna,omitempty"`
+}
+
+// InvoiceQueryPeriod is the year and month the invoices were issued in.
+type InvoiceQueryPeriod struct {
+	Ejercicio string `xml:"sum1:Ejercicio"`
+	Periodo   string `xml:"sum1:Periodo"`
+}
+
+// InvoiceQueryResponse contains the records found by the AEAT.
+type InvoiceQueryResponse struct {
+	XMLName    xml.Name              `xml:"RespuestaConsultaFactuSistemaFacturacion"`
+	Pagination string                `xml:"IndicadorPaginacion"`
+	Result     string                `xml:"ResultadoConsulta"`
+	Records    []*InvoiceQueryRecord `xml:"RegistroRespuestaConsultaFactuSistemaFacturacion"`
+}
+
+// InvoiceQueryRecord describes the current state of an invoice registered
+// with the AEAT.
+type InvoiceQueryRecord struct {
+	ID struct {
+		Issuer string `xml:"IDEmisorFactura"`
+		Code   string `xml:"NumSerieFactura"`
+		Date   string `xml:"FechaExpedicionFactura"`
+	} `xml:"IDFactura"`
+	Data struct {
+		Ref         string `xml:"RefExterna"`
+		Type        string `xml:"TipoFactura"`
+		TaxTotal    string `xml:"CuotaTotal"`
+		Total       string `xml:"ImporteTotal"`
+		GeneratedAt string `xml:"FechaHoraHusoGenRegistro"`
+		Fingerprint string `xml:"Huella"`
+	} `xml:"DatosRegistroFacturacion"`
+	Presentation InvoiceResponsePresentation `xml:"DatosPresentacion"`
+	State        struct {
+		Modified    string `xml:"TimestampUltimaModificacion"`
+		Status      string `xml:"EstadoRegistro"`
+		Code        string `xml:"CodigoErrorRegistro"`
+		Description string `xml:"DescripcionErrorRegistro"`
+	} `xml:"EstadoRegistro"`
+}
+
+// More returns true when the AEAT has more records than those included in
+// the response.
+func (r *InvoiceQueryResponse) More() bool {
+	return r.Pagination == "S"
+}
+
+// Find provides the record that matches the request line, using the external
+// reference first, and then the invoice's identity, in the same way as
+// responses to submissions are matched.
+func (r *InvoiceQueryResponse) Find(line *InvoiceRequestLine) *InvoiceQueryRecord {
+	if ref := line.Ref(); ref != "" {
+		for _, rec := range r.Records {
+			if rec.Data.Ref == ref {
+				return rec
+			}
+		}
+	}
+	k := line.key()
+	for _, rec := range r.Records {
+		if newResultKey(k.op, rec.ID.Issuer, rec.ID.Code, rec.ID.Date) == k {
+			return rec
+		}
+	}
+	return nil
+}
+
+// NewInvoiceQuery prepares a query for the records of the supplier issued in
+// the given month.
+func (c *Client) NewInvoiceQuery(supplier *org.Party, year int, month time.Month) (*InvoiceQuery, error) {
+	if supplier == nil || supplier.TaxID == nil {
+		return nil, ErrValidation.WithMessage("missing supplier or tax id")
+	}
+	q := &InvoiceQuery{
+		Header: &InvoiceQueryHeader{
+			IDVersion: CurrentVersion,
+			Obligado: Issuer{
+				NombreRazon: supplier.Name,
+				NIF:         supplier.TaxID.Code.String(),
+			},
+		},
+		Filter: &InvoiceQueryFilter{
+			Periodo: InvoiceQueryPeriod{
+				Ejercicio: fmt.Sprintf("%04d", year),
+				Periodo:   fmt.Sprintf("%02d", int(month)),
+			},
+		},
+	}
+	n := new(normalizer)
+	n.text("ObligadoEmision.NombreRazon", textName, &q.Header.Obligado.NombreRazon)
+	if n.err != nil {
+		return nil, n.err
+	}
+	return q, nil
+}
+
+// QueryInvoices sends the query to the AEAT and provides the records found.
+func (c *Client) QueryInvoices(ctx context.Context, q *InvoiceQuery) (*InvoiceQueryResponse, error) {
+	if q == nil || q.Header == nil || q.Filter == nil {
+		return nil, ErrValidation.WithMessage("missing query header or filter")
+	}
+	if c.conn == nil {
+		return nil, ErrConnection.WithMessage("no connection available, certificate required")
+	}
+
+	env := newQueryEnvelope()
+	env.Body.InvoiceQuery = q
+	data, err := env.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.conn.post(ctx, OperationQuery, data, []string{q.Header.Obligado.NIF})
+	if err != nil {
+		return nil, err
+	}
+	res := out.Body.QueryResponse
+	if res == nil {
+		return nil, ErrConnection.WithMessage("missing response body")
+	}
+	return res, nil
+}
+
+// QueryRequestLine queries the AEAT for the current state of the invoice
+// included in the request line, which may be used to recover the outcome of
+// submissions that received no response. The line's external reference is
+// used to find the record when available. An ErrMissing error is returned
+// when the AEAT has no record of the invoice.
+func (c *Client) QueryRequestLine(ctx context.Context, supplier *org.Party, line *InvoiceRequestLine) (*InvoiceQueryRecord, error) {
+	rec := line.Record()
+	if rec == nil {
+		return nil, ErrValidation.WithMessage("empty request line")
+	}
+	if err := c.checkEnvironment(line.Environment(), "record"); err != nil {
+		return nil, err
+	}
+	id := rec.Identity()
+	d, err := time.Parse("02-01-2006", id.Date)
+	if err != nil {
+		return nil, ErrValidation.WithMessage(fmt.Sprintf("invalid issue date: %s", id.Date))
+	}
+	q, err := c.NewInvoiceQuery(supplier, d.Year(), d.Month())
+	if err != nil {
+		return nil, err
+	}
+	if ref := line.Ref(); ref != "" {
+		q.Filter.RefExterna = ref
+	} else {
+		q.Filter.NumSerieFactura = strings.TrimSpace(id.Code)
+	}
+	res, err := c.QueryInvoices(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if qr := res.Find(line); qr != nil {
+		return qr, nil
+	}
+	return nil, ErrMissing.WithMessage("record not found")
+}
@@ -0,0 +1,93 @@
+package verifactu_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryInvoices(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	srv := verifactutest.NewServer()
+	t.Cleanup(srv.Close)
+	var ops []verifactu.Operation
+	c, err := verifactu.New(testSoftware,
+		verifactu.WithCurrentTime(ts),
+		verifactu.WithBaseURL(srv.URL),
+		verifactu.WithRefExterna(verifactu.RefFromUUID()),
+		verifactu.WithAuditHook(func(_ context.Context, ex *verifactu.Exchange) {
+			ops = append(ops, ex.Operation)
+		}),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	env := test.LoadEnvelope("inv-base.json")
+	inv := env.Extract().(*bill.Invoice)
+	ir, err := c.NewEnvelopeInvoiceRequest(env, nil)
+	require.NoError(t, err)
+	_, err = c.SendInvoiceRequest(ctx, ir)
+	require.NoError(t, err)
+	line := ir.Lines[0]
+	require.Equal(t, inv.UUID.String(), line.Ref())
+
+	t.Run("period", func(t *testing.T) {
+		q, err := c.NewInvoiceQuery(inv.Supplier, 2024, time.November)
+		require.NoError(t, err)
+		res, err := c.QueryInvoices(ctx, q)
+		require.NoError(t, err)
+		assert.Equal(t, "ConDatos", res.Result)
+		assert.False(t, res.More())
+		require.Len(t, res.Records, 1)
+		rec := res.Records[0]
+		assert.Equal(t, "SAMPLE-004", rec.ID.Code)
+		assert.Equal(t, inv.UUID.String(), rec.Data.Ref)
+		assert.Equal(t, line.Registration.Huella, rec.Data.Fingerprint)
+		assert.Equal(t, verifactu.RecordStatusCorrect, rec.State.Status)
+		assert.Same(t, rec, res.Find(line))
+		assert.Equal(t, verifactu.OperationQuery, ops[len(ops)-1])
+
+		q, err = c.NewInvoiceQuery(inv.Supplier, 2024, time.October)
+		require.NoError(t, err)
+		res, err = c.QueryInvoices(ctx, q)
+		require.NoError(t, err)
+		assert.Equal(t, "SinDatos", res.Result)
+		assert.Nil(t, res.Find(line))
+	})
+
+	t.Run("by external reference", func(t *testing.T) {
+		q, err := c.NewInvoiceQuery(inv.Supplier, 2024, time.November)
+		require.NoError(t, err)
+		q.Filter.RefExterna = "unknown"
+		res, err := c.QueryInvoices(ctx, q)
+		require.NoError(t, err)
+		assert.Empty(t, res.Records)
+
+		rec, err := c.QueryRequestLine(ctx, inv.Supplier, line)
+		require.NoError(t, err)
+		assert.Equal(t, "SAMPLE-004", rec.ID.Code)
+		assert.Equal(t, inv.UUID.String(), rec.Data.Ref)
+	})
+
+	t.Run("missing record", func(t *testing.T) {
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), line.ChainData(), verifactu.WithRef("REF-3"))
+		require.NoError(t, err)
+		_, err = c.QueryRequestLine(ctx, inv.Supplier, &verifactu.InvoiceRequestLine{Registration: reg})
+		assert.ErrorIs(t, err, verifactu.ErrMissing)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := c.QueryInvoices(ctx, nil)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+		_, err = c.NewInvoiceQuery(nil, 2024, time.November)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"time"
+	"unicode/utf8"
 
 	"github.com/invopop/gobl/addons/es/verifactu"
 	"github.com/invopop/gobl/bill"
@@ -56,6 +57,16 @@ type InvoiceRegistration struct {
 	TipoHuella                          string                `xml:"sum1:TipoHuella"`
 	Huella                              string                `xml:"sum1:Huella"`
 	Signature                           *xmldsig.Signature    `xml:"ds:Signature,omitempty"`
+
+	env         Environment
+	warnings    []*Warning
+	diagnostics []*Diagnostic
+}
+
+// Warnings lists the changes made to the invoice data while building the
+// registration so that it would be accepted by the AEAT.
+func (r *InvoiceRegistration) Warnings() []*Warning {
+	return r.warnings
 }
 
 // IDFactura contains the identifying information for an invoice
@@ -177,6 +188,10 @@ func newInvoiceRegistration(inv *bill.Invoice, ts time.Time, s *Software) (*Invo
 		reg.FechaOperacion = inv.OperationDate.Time().Format("02-01-2006")
 	}
 
+	if !hasGeneralNote(inv) {
+		reg.diagnose(DiagnosticDescription, "DescripcionOperacion", "no general note, description inferred from the lines")
+	}
+
 	// Remove untaxed charges from the total. For regimes where ImporteTotal
 	// does not need to match sum(Desglose) (03, 05, 06, 08, 09), only
 	// subtract outlay charges so the total reflects the full sale price.
@@ -185,6 +200,9 @@ func newInvoiceRegistration(inv *bill.Invoice, ts time.Time, s *Software) (*Invo
 		if len(charge.Taxes) == 0 {
 			if !partialBreakdown || charge.Key == bill.ChargeKeyOutlay {
 				reg.ImporteTotal = reg.ImporteTotal.Sub(charge.Amount)
+				reg.diagnose(DiagnosticChargeRemoved, "ImporteTotal", "untaxed charge of %s removed", charge.Amount)
+			} else {
+				reg.diagnose(DiagnosticPartialBreakdown, "ImporteTotal", "untaxed charge of %s kept as the regime only declares part of the operation", charge.Amount)
 			}
 		}
 	}
@@ -195,6 +213,8 @@ func newInvoiceRegistration(inv *bill.Invoice, ts time.Time, s *Software) (*Invo
 				IDDestinatario: p,
 			},
 		}
+	} else if inv.Customer != nil {
+		reg.diagnose(DiagnosticCustomerDropped, "Destinatarios", "customer %q has no usable tax ID or identity", inv.Customer.Name)
 	}
 
 	if itax.Ext.Get(verifactu.ExtKeyDocType).In("F2", "R5") {
@@ -260,6 +280,7 @@ func newInvoiceRegistration(inv *bill.Invoice, ts time.Time, s *Software) (*Invo
 	// Flag for operations with totals over 100,000,000€. Added with optimism.
 	if inv.Totals.TotalWithTax.Compare(num.MakeAmount(100000000, 0)) == 1 {
 		reg.Macrodato = "S"
+		reg.diagnose(DiagnosticMacrodato, "Macrodato", "total of %s exceeds 100,000,000", inv.Totals.TotalWithTax)
 	}
 
 	return reg, nil
@@ -272,6 +293,17 @@ func invoiceNumber(series cbc.Code, code cbc.Code) string {
 	return fmt.Sprintf("%s-%s", series, code)
 }
 
+// hasGeneralNote reports whether the invoice includes the general note used
+// as the description.
+func hasGeneralNote(inv *bill.Invoice) bool {
+	for _, note := range inv.Notes {
+		if note.Key == org.NoteKeyGeneral {
+			return true
+		}
+	}
+	return false
+}
+
 func newDescription(inv *bill.Invoice) string {
 	for _, note := range inv.Notes {
 		if note.Key == org.NoteKeyGeneral {
@@ -285,7 +317,7 @@ func newDescription(inv *bill.Invoice) string {
 		// Only add an item name if it exists
 		if line != nil && line.Item != nil && line.Item.Name != "" {
 			// If the description is too long, we need to stop the loop
-			if len(desc)+len(line.Item.Name)+3 > 500 {
+			if utf8.RuneCountInString(desc)+utf8.RuneCountInString(line.Item.Name)+3 > textDescription.max {
 				// If the description is not empty, add an ellipsis
 				// This could happen if the item name length > 488
 				if desc != "" {
@@ -383,6 +415,8 @@ func (r *InvoiceRegistration) ChainData() *ChainData {
 		NumSeries:   r.IDFactura.NumSerieFactura,
 		IssueDate:   r.IDFactura.FechaExpedicionFactura,
 		Fingerprint: r.Huella,
+		Timestamp:   r.FechaHoraHusoGenRegistro,
+		Environment: r.env,
 	}
 }
 
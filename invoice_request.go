@@ -7,6 +7,16 @@ type InvoiceRequest struct {
 	XMLName xml.Name              `xml:"sum:RegFactuSistemaFacturacion"`
 	Header  *InvoiceRequestHeader `xml:"sum:Cabecera"`
 	Lines   []*InvoiceRequestLine `xml:"sum:RegistroFactura,omitempty"`
+
+	env      Environment
+	warnings []*Warning
+}
+
+// Warnings provides the list of changes made to the header's text fields
+// when the request was prepared. Warnings for each record are available
+// from the records themselves.
+func (req *InvoiceRequest) Warnings() []*Warning {
+	return req.warnings
 }
 
 // InvoiceRequestHeader contains the header information for a VeriFactu document
@@ -17,6 +27,23 @@ type InvoiceRequestHeader struct {
 	// RemisionRequerimiento *RemisionRequerimiento `xml:"sum1:RemisionRequerimiento,omitempty"` // not supported
 }
 
+// clone provides a copy of the header that can be modified independently.
+func (h *InvoiceRequestHeader) clone() *InvoiceRequestHeader {
+	if h == nil {
+		return nil
+	}
+	out := *h
+	if h.Representante != nil {
+		rep := *h.Representante
+		out.Representante = &rep
+	}
+	if h.RemisionVoluntaria != nil {
+		rv := *h.RemisionVoluntaria
+		out.RemisionVoluntaria = &rv
+	}
+	return &out
+}
+
 // Issuer represents an obligated party in the document
 type Issuer struct {
 	NombreRazon string `xml:"sum1:NombreRazon"`
@@ -51,6 +78,17 @@ func (req *InvoiceRequest) AddCancellation(d *InvoiceCancellation) {
 	})
 }
 
+// AddLine adds the registration or cancellation contained in the line
+// to the request body.
+func (req *InvoiceRequest) AddLine(line *InvoiceRequestLine) {
+	switch {
+	case line.Registration != nil:
+		req.AddRegistration(line.Registration)
+	case line.Cancellation != nil:
+		req.AddCancellation(line.Cancellation)
+	}
+}
+
 func (req *InvoiceRequest) addRow(rf *InvoiceRequestLine) {
 	if req.Lines == nil {
 		req.Lines = make([]*InvoiceRequestLine, 0, 1)
@@ -58,6 +96,17 @@ func (req *InvoiceRequest) addRow(rf *InvoiceRequestLine) {
 	req.Lines = append(req.Lines, rf)
 }
 
+// Ref provides the external reference of the record in this line, if any.
+func (line *InvoiceRequestLine) Ref() string {
+	if r := line.Registration; r != nil {
+		return r.RefExterna
+	}
+	if r := line.Cancellation; r != nil {
+		return r.RefExterna
+	}
+	return ""
+}
+
 // ChainData provides the chaining data for this line inside the
 // invoice request.
 func (line *InvoiceRequestLine) ChainData() *ChainData {
@@ -1,8 +1,23 @@
 package verifactu
 
-import "github.com/nbio/xml"
+import (
+	"fmt"
 
-// Default response status
+	"github.com/nbio/xml"
+)
+
+// SubmissionStatus defines the overall status of a request as reported by
+// the AEAT in the EstadoEnvio field.
+type SubmissionStatus string
+
+// Submission status values
+const (
+	SubmissionCorrect          SubmissionStatus = "Correcto"
+	SubmissionPartiallyCorrect SubmissionStatus = "ParcialmenteCorrecto"
+	SubmissionIncorrect        SubmissionStatus = "Incorrecto"
+)
+
+// Default response status for individual lines
 const (
 	StatusCorrect            string = "Correcto"
 	StatusAcceptedWithErrors string = "AceptadoConErrores"
@@ -22,16 +37,26 @@ const (
 // InvoiceResponse defines the response fields from the VeriFactu gateway.
 type InvoiceResponse struct {
 	XMLName xml.Name `xml:"RespuestaRegFactuSistemaFacturacion"`
-	Header  struct {
+	// CSV is the secure verification code assigned by the AEAT to the
+	// submission, only provided when at least one record was accepted.
+	CSV          string                       `xml:"CSV,omitempty"`
+	Presentation *InvoiceResponsePresentation `xml:"DatosPresentacion,omitempty"`
+	Header       struct {
 		Issuer             InvoiceResponseIssuer               `xml:"ObligadoEmision"`
 		Representative     *InvoiceResponseIssuer              `xml:"Representante,omitempty"`
 		RemisionVoluntaria *InvoiceResponseVoluntarySubmission `xml:"sum1:RemisionVoluntaria,omitempty"`
 	} `xml:"Cabecera"`
 	Wait   int                    `xml:"TiempoEsperaEnvio"`
-	Status string                 `xml:"EstadoEnvio"`
+	Status SubmissionStatus       `xml:"EstadoEnvio"`
 	Lines  []*InvoiceResponseLine `xml:"RespuestaLinea"`
 }
 
+// InvoiceResponsePresentation contains the AEAT's receipt of the submission.
+type InvoiceResponsePresentation struct {
+	NIF       string `xml:"NIFPresentador"`
+	Timestamp string `xml:"TimestampPresentacion"`
+}
+
 // InvoiceResponseIssuer maps the response from the invoice request
 // for the issuer.
 type InvoiceResponseIssuer struct {
@@ -103,6 +128,82 @@ func (r *InvoiceResponseLine) Message() string {
 	return r.Status
 }
 
+// Accepted provides the lines that were registered without any issues.
+func (ir *InvoiceResponse) Accepted() []*InvoiceResponseLine {
+	return ir.filter(func(l *InvoiceResponseLine) bool {
+		return l.Status == StatusCorrect || l.Status == StatusCancelled
+	})
+}
+
+// Warned provides the lines that were registered, but with errors that
+// should be corrected with an amendment.
+func (ir *InvoiceResponse) Warned() []*InvoiceResponseLine {
+	return ir.filter(func(l *InvoiceResponseLine) bool {
+		return l.Status == StatusAcceptedWithErrors
+	})
+}
+
+// Rejected provides the lines that were not registered.
+func (ir *InvoiceResponse) Rejected() []*InvoiceResponseLine {
+	return ir.filter(func(l *InvoiceResponseLine) bool {
+		switch l.Status {
+		case StatusCorrect, StatusCancelled, StatusAcceptedWithErrors:
+			return false
+		}
+		return true
+	})
+}
+
+func (ir *InvoiceResponse) filter(fn func(*InvoiceResponseLine) bool) []*InvoiceResponseLine {
+	var out []*InvoiceResponseLine
+	for _, l := range ir.Lines {
+		if fn(l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Error provides a SubmissionError if any of the lines were rejected or the
+// complete request was considered incorrect.
+func (ir *InvoiceResponse) Error() error {
+	rejected := ir.Rejected()
+	if len(rejected) == 0 && ir.Status != SubmissionIncorrect {
+		return nil
+	}
+	return &SubmissionError{
+		Status:   ir.Status,
+		Response: ir,
+		Rejected: rejected,
+	}
+}
+
+// SubmissionError is returned when some or all of the lines of a request were
+// rejected by the AEAT. The complete response is included so that accepted
+// lines may still be processed.
+type SubmissionError struct {
+	Status   SubmissionStatus
+	Response *InvoiceResponse
+	Rejected []*InvoiceResponseLine
+}
+
+// Error provides a summary of the rejected lines.
+func (e *SubmissionError) Error() string {
+	return fmt.Sprintf("submission %s: %d of %d records rejected",
+		e.Status, len(e.Rejected), len(e.Response.Lines),
+	)
+}
+
+// Unwrap provides the errors of each of the rejected lines, so that they can
+// be checked with errors.Is.
+func (e *SubmissionError) Unwrap() []error {
+	out := make([]error, len(e.Rejected))
+	for i, l := range e.Rejected {
+		out[i] = l.Error()
+	}
+	return out
+}
+
 // Bytes prepares an indendented XML document suitable for persistence.
 func (ir *InvoiceResponse) Bytes() ([]byte, error) {
 	return toBytesIndent(ir)
@@ -0,0 +1,102 @@
+package verifactu_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/nbio/xml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvoiceResponseStatus(t *testing.T) {
+	t.Run("split lines", func(t *testing.T) {
+		res := &verifactu.InvoiceResponse{
+			Status: verifactu.SubmissionPartiallyCorrect,
+			Lines: []*verifactu.InvoiceResponseLine{
+				responseLine(verifactu.OpTypeRegistration, "B85905495", "A-1", "13-11-2024", verifactu.StatusCorrect, ""),
+				responseLine(verifactu.OpTypeRegistration, "B85905495", "A-2", "13-11-2024", verifactu.StatusAcceptedWithErrors, "2000"),
+				responseLine(verifactu.OpTypeRegistration, "B85905495", "A-3", "13-11-2024", verifactu.StatusIncorrect, "1100"),
+				responseLine(verifactu.OpTypeCancellation, "B85905495", "A-4", "13-11-2024", verifactu.StatusCorrect, ""),
+			},
+		}
+		assert.Len(t, res.Accepted(), 2)
+		require.Len(t, res.Warned(), 1)
+		assert.Equal(t, "A-2", res.Warned()[0].ID.Code)
+		require.Len(t, res.Rejected(), 1)
+		assert.Equal(t, "A-3", res.Rejected()[0].ID.Code)
+
+		err := res.Error()
+		var serr *verifactu.SubmissionError
+		require.True(t, errors.As(err, &serr))
+		assert.Equal(t, verifactu.SubmissionPartiallyCorrect, serr.Status)
+		assert.Same(t, res, serr.Response)
+		assert.Equal(t, "submission ParcialmenteCorrecto: 1 of 4 records rejected", err.Error())
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+	})
+
+	t.Run("all correct", func(t *testing.T) {
+		res := &verifactu.InvoiceResponse{
+			Status: verifactu.SubmissionCorrect,
+			Lines: []*verifactu.InvoiceResponseLine{
+				responseLine(verifactu.OpTypeRegistration, "B85905495", "A-1", "13-11-2024", verifactu.StatusCorrect, ""),
+			},
+		}
+		assert.NoError(t, res.Error())
+	})
+
+	t.Run("receipt", func(t *testing.T) {
+		data := []byte(`<tikR:RespuestaRegFactuSistemaFacturacion xmlns:tikR="https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/RespuestaSuministro.xsd" xmlns:tik="https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/SuministroInformacion.xsd">
+	<tikR:CSV>A-Y23JP3582934</tikR:CSV>
+	<tikR:DatosPresentacion>
+		<tik:NIFPresentador>B85905495</tik:NIFPresentador>
+		<tik:TimestampPresentacion>2024-11-26T05:00:12+01:00</tik:TimestampPresentacion>
+	</tikR:DatosPresentacion>
+	<tikR:EstadoEnvio>Correcto</tikR:EstadoEnvio>
+</tikR:RespuestaRegFactuSistemaFacturacion>`)
+		res := new(verifactu.InvoiceResponse)
+		require.NoError(t, xml.Unmarshal(data, res))
+		assert.Equal(t, "A-Y23JP3582934", res.CSV)
+		require.NotNil(t, res.Presentation)
+		assert.Equal(t, "B85905495", res.Presentation.NIF)
+		assert.Equal(t, "2024-11-26T05:00:12+01:00", res.Presentation.Timestamp)
+	})
+
+	t.Run("send partially rejected batch", func(t *testing.T) {
+		ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+		require.NoError(t, err)
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL))
+		require.NoError(t, err)
+
+		env, inv := test.LoadInvoice("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		reg2, err := c.RegisterInvoice(test.LoadEnvelope("inv-tax-inc.json"), reg.ChainData())
+		require.NoError(t, err)
+
+		ir, err := c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg)
+		_, err = c.SendInvoiceRequest(context.Background(), ir)
+		require.NoError(t, err)
+
+		ir, err = c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg)
+		ir.AddRegistration(reg2)
+		res, err := c.SendInvoiceRequest(context.Background(), ir)
+		require.NotNil(t, res)
+		assert.Equal(t, verifactu.SubmissionPartiallyCorrect, res.Status)
+		var serr *verifactu.SubmissionError
+		require.ErrorAs(t, err, &serr)
+		assert.Len(t, serr.Rejected, 1)
+		assert.ErrorIs(t, err, verifactu.ErrDuplicate)
+	})
+}
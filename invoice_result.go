@@ -0,0 +1,154 @@
+package verifactu
+
+import "strings"
+
+// InvoiceResult pairs a line from an invoice request with the response line
+// provided by the AEAT for the same record. When the AEAT did not return a
+// line for the submitted record, the response will be nil and the result
+// is considered missing.
+type InvoiceResult struct {
+	Request  *InvoiceRequestLine
+	Response *InvoiceResponseLine
+	// CSV and Presentation contain the AEAT's receipt of the submission the
+	// record was included in, if any.
+	CSV          string
+	Presentation *InvoiceResponsePresentation
+}
+
+// resultKey is used to match request and response lines.
+type resultKey struct {
+	op     OpType
+	issuer string
+	code   string
+	date   string
+}
+
+// refKey is used to match request and response lines by their external
+// reference.
+type refKey struct {
+	op  OpType
+	ref string
+}
+
+// Results pairs each of the lines in the original request with the matching
+// line from the response. Lines are matched using their external reference
+// first, and then using the invoice's identity. The order of the request lines
+// is maintained, and every request line will have a result, even when no
+// response line could be found.
+func (ir *InvoiceResponse) Results(req *InvoiceRequest) []*InvoiceResult {
+	if req == nil {
+		return nil
+	}
+
+	// Group response lines by key, maintaining their order so that repeated
+	// records in the same batch are paired in sequence.
+	byRef := make(map[refKey][]*InvoiceResponseLine)
+	byID := make(map[resultKey][]*InvoiceResponseLine)
+	if ir != nil {
+		for _, line := range ir.Lines {
+			if line.Ref != "" {
+				k := refKey{line.Operation.Type, line.Ref}
+				byRef[k] = append(byRef[k], line)
+			}
+			k := line.key()
+			byID[k] = append(byID[k], line)
+		}
+	}
+
+	used := make(map[*InvoiceResponseLine]bool)
+	take := func(list []*InvoiceResponseLine) *InvoiceResponseLine {
+		for _, l := range list {
+			if !used[l] {
+				used[l] = true
+				return l
+			}
+		}
+		return nil
+	}
+
+	out := make([]*InvoiceResult, len(req.Lines))
+	for i, line := range req.Lines {
+		r := &InvoiceResult{Request: line}
+		if ir != nil {
+			r.CSV = ir.CSV
+			r.Presentation = ir.Presentation
+		}
+		if ref := line.Ref(); ref != "" {
+			r.Response = take(byRef[refKey{line.key().op, ref}])
+		}
+		if r.Response == nil {
+			r.Response = take(byID[line.key()])
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// Ref provides the external reference of the submitted record.
+func (r *InvoiceResult) Ref() string {
+	return r.Request.Ref()
+}
+
+// Missing returns true when the AEAT did not provide a response line for the
+// submitted record.
+func (r *InvoiceResult) Missing() bool {
+	return r.Response == nil
+}
+
+// Error provides the error for the individual record, if any. Missing
+// response lines will result in an ErrMissing error.
+func (r *InvoiceResult) Error() error {
+	if r.Response == nil {
+		return ErrMissing
+	}
+	return r.Response.Error()
+}
+
+// Duplicated provides the details of the duplicated record registered by the
+// AEAT, if any.
+func (r *InvoiceResult) Duplicated() *InvoiceResponseLineDuplicated {
+	if r.Response == nil {
+		return nil
+	}
+	return r.Response.Duplicated
+}
+
+// ChainData provides the chaining data of the submitted record.
+func (r *InvoiceResult) ChainData() *ChainData {
+	return r.Request.ChainData()
+}
+
+// key prepares the matching key for the request line.
+func (line *InvoiceRequestLine) key() resultKey {
+	if r := line.Registration; r != nil && r.IDFactura != nil {
+		return newResultKey(
+			OpTypeRegistration,
+			r.IDFactura.IDEmisorFactura,
+			r.IDFactura.NumSerieFactura,
+			r.IDFactura.FechaExpedicionFactura,
+		)
+	}
+	if c := line.Cancellation; c != nil && c.IDFactura != nil {
+		return newResultKey(
+			OpTypeCancellation,
+			c.IDFactura.IDEmisorFactura,
+			c.IDFactura.NumSerieFactura,
+			c.IDFactura.FechaExpedicionFactura,
+		)
+	}
+	return resultKey{}
+}
+
+// key prepares the matching key for the response line.
+func (r *InvoiceResponseLine) key() resultKey {
+	return newResultKey(r.Operation.Type, r.ID.Issuer, r.ID.Code, r.ID.Date)
+}
+
+func newResultKey(op OpType, issuer, code, date string) resultKey {
+	return resultKey{
+		op:     op,
+		issuer: strings.ToUpper(strings.TrimSpace(issuer)),
+		code:   strings.TrimSpace(code),
+		date:   strings.TrimSpace(date),
+	}
+}
@@ -0,0 +1,118 @@
+package verifactu_test
+
+import (
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvoiceResponseResults(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	vc, err := verifactu.New(verifactu.Software{}, verifactu.WithCurrentTime(ts))
+	require.NoError(t, err)
+
+	env, inv := test.LoadInvoice("inv-base.json")
+	reg, err := vc.RegisterInvoice(env, nil)
+	require.NoError(t, err)
+	can, err := vc.CancelInvoice(env, reg.ChainData())
+	require.NoError(t, err)
+	env2 := test.LoadEnvelope("inv-op-date.json")
+	reg2, err := vc.RegisterInvoice(env2, can.ChainData())
+	require.NoError(t, err)
+
+	ir, err := vc.NewInvoiceRequest(inv.Supplier)
+	require.NoError(t, err)
+	ir.AddRegistration(reg)
+	ir.AddCancellation(can)
+	ir.AddRegistration(reg2)
+
+	t.Run("mixed registration and cancellation batch", func(t *testing.T) {
+		res := &verifactu.InvoiceResponse{
+			Status: "ParcialmenteCorrecto",
+			Lines: []*verifactu.InvoiceResponseLine{
+				// returned in a different order to the request
+				responseLine(verifactu.OpTypeCancellation, "B85905495", "SAMPLE-004", "13-11-2024", verifactu.StatusCorrect, ""),
+				responseLine(verifactu.OpTypeRegistration, "B85905495", "SAMPLE-004", "13-11-2024", verifactu.StatusAcceptedWithErrors, "2000"),
+			},
+		}
+
+		results := res.Results(ir)
+		require.Len(t, results, 3)
+
+		assert.Same(t, ir.Lines[0], results[0].Request)
+		require.NotNil(t, results[0].Response)
+		assert.Equal(t, verifactu.OpTypeRegistration, results[0].Response.Operation.Type)
+		assert.ErrorIs(t, results[0].Error(), verifactu.ErrWarning)
+		assert.Equal(t, reg.ChainData(), results[0].ChainData())
+
+		assert.Same(t, ir.Lines[1], results[1].Request)
+		require.NotNil(t, results[1].Response)
+		assert.Equal(t, verifactu.OpTypeCancellation, results[1].Response.Operation.Type)
+		assert.NoError(t, results[1].Error())
+		assert.Equal(t, can.ChainData(), results[1].ChainData())
+
+		assert.True(t, results[2].Missing())
+		assert.ErrorIs(t, results[2].Error(), verifactu.ErrMissing)
+		assert.Nil(t, results[2].Duplicated())
+		assert.Equal(t, reg2.ChainData(), results[2].ChainData())
+	})
+
+	t.Run("duplicated record", func(t *testing.T) {
+		line := responseLine(verifactu.OpTypeRegistration, "B85905495", "SAMPLE-004", "13-11-2024", verifactu.StatusIncorrect, "3000")
+		line.Duplicated = &verifactu.InvoiceResponseLineDuplicated{
+			ID:     "12345",
+			Status: "Correcta",
+		}
+		res := &verifactu.InvoiceResponse{Lines: []*verifactu.InvoiceResponseLine{line}}
+
+		results := res.Results(ir)
+		require.Len(t, results, 3)
+		assert.ErrorIs(t, results[0].Error(), verifactu.ErrDuplicate)
+		require.NotNil(t, results[0].Duplicated())
+		assert.Equal(t, "12345", results[0].Duplicated().ID)
+		assert.True(t, results[1].Missing())
+	})
+
+	t.Run("matched by external reference", func(t *testing.T) {
+		reg, err := vc.RegisterInvoice(env, nil, verifactu.WithRef("REF-1"))
+		require.NoError(t, err)
+		ir, err := vc.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg)
+
+		// identity differs in the response, for example due to formatting
+		line := responseLine(verifactu.OpTypeRegistration, "B85905495", "SAMPLE004", "13-11-2024", verifactu.StatusCorrect, "")
+		line.Ref = "REF-1"
+		res := &verifactu.InvoiceResponse{Lines: []*verifactu.InvoiceResponseLine{line}}
+
+		results := res.Results(ir)
+		require.Len(t, results, 1)
+		assert.Same(t, line, results[0].Response)
+		assert.Equal(t, "REF-1", results[0].Ref())
+	})
+
+	t.Run("nil response", func(t *testing.T) {
+		var res *verifactu.InvoiceResponse
+		results := res.Results(ir)
+		require.Len(t, results, 3)
+		for _, r := range results {
+			assert.True(t, r.Missing())
+		}
+	})
+}
+
+func responseLine(op verifactu.OpType, nif, code, date, status, errCode string) *verifactu.InvoiceResponseLine {
+	line := new(verifactu.InvoiceResponseLine)
+	line.ID.Issuer = nif
+	line.ID.Code = code
+	line.ID.Date = date
+	line.Operation.Type = op
+	line.Status = status
+	line.Code = errCode
+	return line
+}
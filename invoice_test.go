@@ -9,6 +9,7 @@ import (
 	addon "github.com/invopop/gobl/addons/es/verifactu"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/currency"
 	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
@@ -229,3 +230,97 @@ func TestPartialBreakdownRegime(t *testing.T) {
 		assert.Equal(t, "2178.00", ra.ImporteTotal.String())
 	})
 }
+
+func TestCurrencyConversion(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	vc, err := verifactu.New(
+		verifactu.Software{},
+		verifactu.WithCurrentTime(ts),
+	)
+	require.NoError(t, err)
+
+	t.Run("converts export in USD", func(t *testing.T) {
+		env := test.LoadEnvelope("export-us-usd.json")
+		ra, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, "1754.83", ra.Desglose.DetalleDesglose[0].BaseImponibleOImporteNoSujeto)
+		assert.Equal(t, "0.00", ra.CuotaTotal.String())
+		assert.Equal(t, "1754.83", ra.ImporteTotal.String())
+	})
+
+	t.Run("converts taxed invoice in GBP", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		inv.Currency = "GBP"
+		inv.ExchangeRates = []*currency.ExchangeRate{
+			{From: "GBP", To: "EUR", Amount: num.MakeAmount(11689, 4)},
+		}
+		inv.Lines[0].Item.Price = num.NewAmount(3333, 2)
+		ra, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+
+		dd := ra.Desglose.DetalleDesglose[0]
+		assert.Equal(t, "779.19", dd.BaseImponibleOImporteNoSujeto)
+		assert.Equal(t, "163.63", dd.CuotaRepercutida)
+		assert.Equal(t, "163.63", ra.CuotaTotal.String())
+		assert.Equal(t, "942.82", ra.ImporteTotal.String())
+		assert.Nil(t, verifactu.ValidateRegistration(ra))
+	})
+
+	t.Run("converts preceding totals", func(t *testing.T) {
+		env, inv := test.LoadInvoice("corrective-base.json")
+		inv.Currency = "USD"
+		inv.ExchangeRates = []*currency.ExchangeRate{
+			{From: "USD", To: "EUR", Amount: num.MakeAmount(5, 1)},
+		}
+		ra, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+
+		require.NotNil(t, ra.ImporteRectificacion)
+		assert.Equal(t, "810.00", ra.ImporteRectificacion.BaseRectificada.String())
+		assert.Equal(t, "170.10", ra.ImporteRectificacion.CuotaRectificada.String())
+		assert.Equal(t, "340.20", inv.Preceding[0].Tax.Sum.String(), "original unchanged")
+	})
+
+	t.Run("missing exchange rate", func(t *testing.T) {
+		env, inv := test.LoadInvoice("inv-base.json")
+		inv.Currency = "USD"
+		_, err := vc.RegisterInvoice(env, nil)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+		assert.ErrorContains(t, err, "missing exchange rate from USD to EUR")
+	})
+}
+
+func TestRegisterInvoiceKeepsEnvelope(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	vc, err := verifactu.New(
+		verifactu.Software{},
+		verifactu.WithCurrentTime(ts),
+	)
+	require.NoError(t, err)
+
+	for _, name := range []string{"cred-note-base.json", "export-us-usd.json"} {
+		t.Run(name, func(t *testing.T) {
+			env := test.LoadEnvelope(name)
+			digest, err := env.Digest()
+			require.NoError(t, err)
+
+			ra1, err := vc.RegisterInvoice(env, nil)
+			require.NoError(t, err)
+			ra2, err := vc.RegisterInvoice(env, nil)
+			require.NoError(t, err)
+			_, err = vc.NewEnvelopeInvoiceRequest(env, nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, ra1.ImporteTotal.String(), ra2.ImporteTotal.String())
+			assert.Equal(t, ra1.Huella, ra2.Huella)
+
+			after, err := env.Digest()
+			require.NoError(t, err)
+			assert.Equal(t, digest.Value, after.Value)
+			assert.Equal(t, digest.Value, env.Head.Digest.Value)
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package verifactu
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // fallback for systems without timezone data
+)
+
+// Locations of the Spanish time zones used in record timestamps.
+const (
+	LocationPeninsula     = "Europe/Madrid"
+	LocationCanaryIslands = "Atlantic/Canary"
+)
+
+// defaultLocation is used for record timestamps unless the client or
+// generate options define another.
+var defaultLocation = loadLocation(LocationPeninsula)
+
+// loadLocation loads the named location. The embedded timezone data means
+// this can only fail in a broken build, in which case we panic rather than
+// risk timestamps with the wrong offset ending up in record fingerprints.
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("verifactu: loading %s location: %v", name, err))
+	}
+	return loc
+}
+
+// WithLocation sets the time zone used for the timestamps of the records
+// generated by the client, such as the "Atlantic/Canary" location used by
+// installations in the Canary Islands. Records use "Europe/Madrid" by default.
+func WithLocation(loc *time.Location) Option {
+	return func(c *Client) {
+		c.location = loc
+	}
+}
+
+// InLocation overrides the client's time zone for the generated record. It is
+// useful alongside WithInstallationNumber when installations in different time
+// zones share the same client.
+func InLocation(loc *time.Location) GenerateOption {
+	return func(o *generateOptions) {
+		o.location = loc
+	}
+}
+
+// Location provides the time zone used for the timestamps of the records
+// generated by the client.
+func (c *Client) Location() *time.Location {
+	if c.location != nil {
+		return c.location
+	}
+	return defaultLocation
+}
+
+// recordTime provides the current time in the location to use for the record.
+func (c *Client) recordTime(o *generateOptions) time.Time {
+	loc := o.location
+	if loc == nil {
+		loc = c.Location()
+	}
+	return c.CurrentTime().In(loc)
+}
@@ -0,0 +1,87 @@
+package verifactu_test
+
+import (
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocation(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	canary, err := time.LoadLocation(verifactu.LocationCanaryIslands)
+	require.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.LocationPeninsula, c.Location().String())
+
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "2024-11-26T05:00:00+01:00", reg.FechaHoraHusoGenRegistro)
+	})
+
+	t.Run("canary islands", func(t *testing.T) {
+		mad, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithLocation(canary),
+		)
+		require.NoError(t, err)
+
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "2024-11-26T04:00:00+00:00", reg.FechaHoraHusoGenRegistro)
+
+		// The offset is part of the fingerprint, and the timestamp parses
+		// back to the same instant.
+		reg2, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, reg.Huella, reg2.Huella)
+		regMad, err := mad.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.NotEqual(t, regMad.Huella, reg.Huella)
+		parsed, err := time.Parse(time.RFC3339, reg.FechaHoraHusoGenRegistro)
+		require.NoError(t, err)
+		assert.True(t, parsed.Equal(ts))
+
+		data, err := reg.Bytes()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "<sum1:FechaHoraHusoGenRegistro>2024-11-26T04:00:00+00:00</sum1:FechaHoraHusoGenRegistro>")
+
+		can, err := c.CancelInvoice(test.LoadEnvelope("inv-base.json"), reg.ChainData())
+		require.NoError(t, err)
+		assert.Equal(t, "2024-11-26T04:00:00+00:00", can.FechaHoraHusoGenRegistro)
+	})
+
+	t.Run("summer time", func(t *testing.T) {
+		summer, err := time.Parse(time.RFC3339, "2025-07-01T10:00:00Z")
+		require.NoError(t, err)
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(summer),
+			verifactu.WithLocation(canary),
+		)
+		require.NoError(t, err)
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, "2025-07-01T11:00:00+01:00", reg.FechaHoraHusoGenRegistro)
+	})
+
+	t.Run("record override", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		can, err := c.CancelInvoice(test.LoadEnvelope("inv-base.json"), nil, verifactu.InLocation(canary))
+		require.NoError(t, err)
+		assert.Equal(t, "2024-11-26T04:00:00+00:00", can.FechaHoraHusoGenRegistro)
+
+		evt, err := c.RegisterEvent(test.LoadEnvelope("status-system-startup.json"), nil, verifactu.InLocation(canary))
+		require.NoError(t, err)
+		assert.Equal(t, "2024-11-26T04:00:00+00:00", evt.ChainData().GenerationTimestamp)
+	})
+}
@@ -0,0 +1,193 @@
+package verifactu
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Warning describes a change made to a value while building a record so
+// that it complies with the formats accepted by the AEAT.
+type Warning struct {
+	// Field is the name of the XML element that was changed.
+	Field string `json:"field"`
+	// Original contains the value before it was changed.
+	Original string `json:"original"`
+	// Message describes the changes made.
+	Message string `json:"message"`
+}
+
+// String provides a human readable version of the warning.
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Field, w.Message)
+}
+
+// textKind describes the restrictions applied to a type of text field.
+type textKind struct {
+	// max is the maximum number of characters.
+	max int
+	// fixed values cannot be truncated, as they are used to correlate
+	// records.
+	fixed bool
+	// identifier values cannot be changed beyond trimming, as they are
+	// used to identify records.
+	identifier bool
+}
+
+// Text field kinds, based on the types defined in the XSD.
+var (
+	textName          = textKind{max: 120}
+	textDescription   = textKind{max: 500}
+	textRef           = textKind{max: 60, fixed: true}
+	textInvoiceNumber = textKind{max: 60, identifier: true}
+	textOtherID       = textKind{max: 20, identifier: true}
+	textAgreement     = textKind{max: 15, identifier: true}
+	textAgreementID   = textKind{max: 16, identifier: true}
+)
+
+// normalizer cleans up the text values of a record, keeping track of the
+// changes made and the first value that could not be fixed.
+type normalizer struct {
+	warnings []*Warning
+	err      error
+}
+
+// text normalizes the value according to the kind of field:
+//
+//   - surrounding whitespace is removed,
+//   - control characters are replaced by spaces in free text,
+//   - free text is truncated to the maximum number of characters, unless
+//     fixed, and
+//   - identifiers must contain printable ASCII characters only and fit
+//     within the maximum length.
+func (n *normalizer) text(field string, kind textKind, v *string) {
+	if v == nil || *v == "" {
+		return
+	}
+	orig := *v
+	if !utf8.ValidString(orig) {
+		n.fail(field, "invalid UTF-8 characters")
+		return
+	}
+
+	var changes []string
+	s := strings.TrimSpace(orig)
+	if s != orig {
+		changes = append(changes, "trimmed")
+	}
+
+	if kind.identifier {
+		for _, r := range s {
+			if r < 0x20 || r > 0x7E {
+				n.fail(field, fmt.Sprintf("invalid character %q", r))
+				return
+			}
+		}
+		if len(s) > kind.max {
+			n.fail(field, fmt.Sprintf("longer than %d characters", kind.max))
+			return
+		}
+	} else {
+		if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+			s = strings.Map(func(r rune) rune {
+				if unicode.IsControl(r) {
+					return ' '
+				}
+				return r
+			}, s)
+			changes = append(changes, "control characters replaced")
+		}
+		if utf8.RuneCountInString(s) > kind.max {
+			if kind.fixed {
+				n.fail(field, fmt.Sprintf("longer than %d characters", kind.max))
+				return
+			}
+			s = strings.TrimSpace(string([]rune(s)[:kind.max]))
+			changes = append(changes, fmt.Sprintf("truncated to %d characters", kind.max))
+		}
+	}
+
+	if len(changes) > 0 {
+		*v = s
+		n.warnings = append(n.warnings, &Warning{
+			Field:    field,
+			Original: orig,
+			Message:  strings.Join(changes, ", "),
+		})
+	}
+}
+
+// party normalizes the name and identity of the party.
+func (n *normalizer) party(field string, p *Party) {
+	if p == nil {
+		return
+	}
+	n.text(field+".NombreRazon", textName, &p.NombreRazon)
+	if p.IDOtro != nil {
+		n.text(field+".IDOtro.ID", textOtherID, &p.IDOtro.ID)
+	}
+}
+
+func (n *normalizer) fail(field, msg string) {
+	if n.err == nil {
+		n.err = ErrValidation.WithMessage(fmt.Sprintf("%s: %s", field, msg))
+	}
+}
+
+// normalize cleans up the registration's text fields, returning a validation
+// error if any of them cannot be fixed.
+func (r *InvoiceRegistration) normalize() error {
+	n := new(normalizer)
+	if r.IDFactura != nil {
+		n.text("NumSerieFactura", textInvoiceNumber, &r.IDFactura.NumSerieFactura)
+	}
+	n.text("RefExterna", textRef, &r.RefExterna)
+	n.text("NombreRazonEmisor", textName, &r.NombreRazonEmisor)
+	n.text("DescripcionOperacion", textDescription, &r.DescripcionOperacion)
+	if r.FacturasRectificadas != nil {
+		for _, f := range r.FacturasRectificadas.Items {
+			n.text("FacturasRectificadas.NumSerieFactura", textInvoiceNumber, &f.NumSerieFactura)
+		}
+	}
+	if r.FacturasSustituidas != nil {
+		for _, f := range r.FacturasSustituidas.Items {
+			n.text("FacturasSustituidas.NumSerieFactura", textInvoiceNumber, &f.NumSerieFactura)
+		}
+	}
+	n.text("NumRegistroAcuerdoFacturacion", textAgreement, &r.NumRegistroAcuerdoFacturacion)
+	n.text("IdAcuerdoSistemaInformatico", textAgreementID, &r.IdAcuerdoSistemaInformatico)
+	n.party("Tercero", r.Tercero)
+	for _, d := range r.Destinatarios {
+		n.party("Destinatarios.IDDestinatario", d.IDDestinatario)
+	}
+	r.warnings = n.warnings
+	return n.err
+}
+
+// normalize cleans up the text fields of the request header, returning a
+// validation error if any of them cannot be fixed.
+func (req *InvoiceRequest) normalize() error {
+	n := new(normalizer)
+	if h := req.Header; h != nil {
+		n.text("ObligadoEmision.NombreRazon", textName, &h.Obligado.NombreRazon)
+		if h.Representante != nil {
+			n.text("Representante.NombreRazon", textName, &h.Representante.NombreRazon)
+		}
+	}
+	req.warnings = n.warnings
+	return n.err
+}
+
+// normalize cleans up the cancellation's text fields, returning a validation
+// error if any of them cannot be fixed.
+func (c *InvoiceCancellation) normalize() error {
+	n := new(normalizer)
+	if c.IDFactura != nil {
+		n.text("NumSerieFacturaAnulada", textInvoiceNumber, &c.IDFactura.NumSerieFactura)
+	}
+	n.text("RefExterna", textRef, &c.RefExterna)
+	n.party("Generador", c.Generador)
+	c.warnings = n.warnings
+	return n.err
+}
@@ -0,0 +1,145 @@
+package verifactu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		kind     textKind
+		value    string
+		expected string
+		warning  string
+		err      string
+	}{
+		{
+			name:     "unchanged",
+			kind:     textName,
+			value:    "Invopop S.L.",
+			expected: "Invopop S.L.",
+		},
+		{
+			name:     "trimmed",
+			kind:     textName,
+			value:    "  Invopop S.L. ",
+			expected: "Invopop S.L.",
+			warning:  "trimmed",
+		},
+		{
+			name:     "control characters",
+			kind:     textDescription,
+			value:    "Servicios\nde\tdesarrollo",
+			expected: "Servicios de desarrollo",
+			warning:  "control characters replaced",
+		},
+		{
+			name:     "truncated by runes",
+			kind:     textName,
+			value:    strings.Repeat("ñ", 130),
+			expected: strings.Repeat("ñ", 120),
+			warning:  "truncated to 120 characters",
+		},
+		{
+			name:     "identifier trimmed",
+			kind:     textInvoiceNumber,
+			value:    "SAMPLE-001 ",
+			expected: "SAMPLE-001",
+			warning:  "trimmed",
+		},
+		{
+			name:  "identifier with invalid characters",
+			kind:  textInvoiceNumber,
+			value: "FACTURA-Nº1",
+			err:   "NumSerieFactura: invalid character 'º'",
+		},
+		{
+			name:  "identifier too long",
+			kind:  textInvoiceNumber,
+			value: strings.Repeat("A", 61),
+			err:   "NumSerieFactura: longer than 60 characters",
+		},
+		{
+			name:  "invalid UTF-8",
+			kind:  textName,
+			value: "Invopop \xff",
+			err:   "NumSerieFactura: invalid UTF-8 characters",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := new(normalizer)
+			v := tt.value
+			n.text("NumSerieFactura", tt.kind, &v)
+			if tt.err != "" {
+				require.Error(t, n.err)
+				assert.ErrorIs(t, n.err, ErrValidation)
+				assert.Contains(t, n.err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, n.err)
+			assert.Equal(t, tt.expected, v)
+			if tt.warning == "" {
+				assert.Empty(t, n.warnings)
+				return
+			}
+			require.Len(t, n.warnings, 1)
+			assert.Equal(t, tt.value, n.warnings[0].Original)
+			assert.Contains(t, n.warnings[0].Message, tt.warning)
+		})
+	}
+}
+
+func TestInvoiceRequestNormalize(t *testing.T) {
+	req := &InvoiceRequest{
+		Header: &InvoiceRequestHeader{
+			Obligado: Issuer{
+				NombreRazon: " Invopop\tS.L. ",
+				NIF:         "B85905495",
+			},
+			Representante: &Issuer{
+				NombreRazon: strings.Repeat("Gestoría ", 15),
+				NIF:         "B63272603",
+			},
+		},
+	}
+	require.NoError(t, req.normalize())
+	assert.Equal(t, "Invopop S.L.", req.Header.Obligado.NombreRazon)
+	assert.Len(t, []rune(req.Header.Representante.NombreRazon), 120)
+
+	w := req.Warnings()
+	require.Len(t, w, 2)
+	assert.Equal(t, "ObligadoEmision.NombreRazon: trimmed, control characters replaced", w[0].String())
+	assert.Equal(t, "Representante.NombreRazon: trimmed, truncated to 120 characters", w[1].String())
+
+	req.Header.Obligado.NombreRazon = "Invopop \xff"
+	assert.ErrorIs(t, req.normalize(), ErrValidation)
+}
+
+func TestInvoiceRegistrationNormalize(t *testing.T) {
+	reg := &InvoiceRegistration{
+		IDFactura: &IDFactura{
+			NumSerieFactura: "SAMPLE-001",
+		},
+		NombreRazonEmisor:    strings.Repeat("Invopop ", 20),
+		DescripcionOperacion: "Desarrollo",
+		Destinatarios: []*Destinatario{
+			{IDDestinatario: &Party{NombreRazon: " Sample Consumer "}},
+		},
+	}
+	require.NoError(t, reg.normalize())
+	assert.Len(t, []rune(reg.NombreRazonEmisor), 119, "trailing space removed")
+	assert.Equal(t, "Sample Consumer", reg.Destinatarios[0].IDDestinatario.NombreRazon)
+
+	w := reg.Warnings()
+	require.Len(t, w, 2)
+	assert.Equal(t, "NombreRazonEmisor: trimmed, truncated to 120 characters", w[0].String())
+	assert.Equal(t, "Destinatarios.IDDestinatario.NombreRazon: trimmed", w[1].String())
+
+	reg.IDFactura.NumSerieFactura = "SAMPLE\n001"
+	assert.ErrorIs(t, reg.normalize(), ErrValidation)
+}
@@ -0,0 +1,105 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+var legendFont = sync.OnceValue(func() *opentype.Font {
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		panic(fmt.Errorf("qr: parsing font: %w", err))
+	}
+	return f
+})
+
+// textWidth provides the width of the text relative to a font size of 1.
+func textWidth(text string) float64 {
+	face, err := opentype.NewFace(legendFont(), &opentype.FaceOptions{
+		Size: 100,
+		DPI:  72,
+	})
+	if err != nil {
+		return float64(len(text))
+	}
+	defer face.Close() //nolint:errcheck
+	w := font.MeasureString(face, text)
+	return float64(w) / 64 / 100
+}
+
+// PNG renders the QR code as a PNG image. The size in pixels is determined by
+// the DPI option, using a whole number of pixels per module so the image
+// remains sharp. An error is returned if the resolution is too low to respect
+// the size limits.
+func (c *Code) PNG() ([]byte, error) {
+	img, err := c.Image()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, fmt.Errorf("qr: encoding png: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Image renders the QR code as a grayscale image, as used by PNG.
+func (c *Code) Image() (*image.Gray, error) {
+	l := c.layout()
+	target := c.opts.size / 25.4 * float64(c.opts.dpi)
+	scale := int(math.Ceil(target / float64(l.symbol)))
+	if mm := float64(scale*l.symbol) / float64(c.opts.dpi) * 25.4; mm > MaxSize {
+		return nil, fmt.Errorf("%w: %d dpi is too low to render a %.1fmm symbol", ErrSize, c.opts.dpi, c.opts.size)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, l.width*scale, l.height*scale))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y, row := range c.modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			r := image.Rect(
+				(QuietZone+x)*scale, (l.top+y)*scale,
+				(QuietZone+x+1)*scale, (l.top+y+1)*scale,
+			)
+			draw.Draw(img, r, image.Black, image.Point{}, draw.Src)
+		}
+	}
+
+	if l.font > 0 {
+		face, err := opentype.NewFace(legendFont(), &opentype.FaceOptions{
+			Size:    l.font * float64(scale),
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("qr: preparing font: %w", err)
+		}
+		defer face.Close() //nolint:errcheck
+		d := &font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(color.Black),
+			Face: face,
+		}
+		w := d.MeasureString(c.opts.legend)
+		x := (fixed.I(img.Bounds().Dx()) - w) / 2
+		// baseline sits just above the quiet zone
+		y := fixed.I((l.top - QuietZone) * scale)
+		y -= face.Metrics().Descent
+		d.Dot = fixed.Point26_6{X: x, Y: y}
+		d.DrawString(c.opts.legend)
+	}
+	return img, nil
+}
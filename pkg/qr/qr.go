@@ -0,0 +1,189 @@
+// Package qr renders the VeriFactu QR codes to be printed on invoices as PNG
+// or SVG images, following the requirements of the Ministerial Order
+// HAC/1177/2024: ISO/IEC 18004 symbols with error correction level M, between
+// 30x30 and 40x40 millimetres, surrounded by a quiet zone and accompanied by
+// the legend identifying VERI*FACTU invoices.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/invopop/gobl"
+	verifactu "github.com/invopop/gobl.verifactu"
+	vf "github.com/invopop/gobl/addons/es/verifactu"
+	"github.com/invopop/gobl/head"
+	"github.com/skip2/go-qrcode"
+)
+
+// Size limits of the QR symbol in millimetres, excluding the quiet zone and
+// legend.
+const (
+	MinSize     = 30.0
+	MaxSize     = 40.0
+	DefaultSize = 30.0
+)
+
+// DefaultDPI is the resolution used to size PNG images.
+const DefaultDPI = 300
+
+// QuietZone is the number of blank modules surrounding the symbol, as
+// required by ISO/IEC 18004.
+const QuietZone = 4
+
+// Legends that may be included above the QR code. Invoices issued by
+// VERI*FACTU systems must include one of them, while those issued by NO
+// VERI*FACTU systems must not.
+const (
+	LegendVerifactu  = "VERI*FACTU"
+	LegendVerifiable = "Factura verificable en la sede electrónica de la AEAT"
+)
+
+// legendSize is the preferred height of the legend text in millimetres, reduced
+// when needed to fit the width of the image.
+const legendSize = 3.0
+
+// Errors returned when preparing codes.
+var (
+	ErrNoStamp = errors.New("qr: envelope does not contain a QR stamp")
+	ErrSize    = errors.New("qr: invalid size")
+)
+
+type options struct {
+	size   float64
+	dpi    int
+	legend string
+}
+
+// Option is used to customise the generated images.
+type Option func(*options)
+
+// WithSize sets the width and height of the QR symbol in millimetres, which
+// must be between MinSize and MaxSize.
+func WithSize(mm float64) Option {
+	return func(o *options) {
+		o.size = mm
+	}
+}
+
+// WithDPI sets the resolution the PNG image is expected to be printed at, used
+// to determine its size in pixels. SVG images use absolute units instead.
+func WithDPI(dpi int) Option {
+	return func(o *options) {
+		o.dpi = dpi
+	}
+}
+
+// WithLegend replaces the legend shown above the QR code. An empty legend
+// removes it, as required for invoices issued by NO VERI*FACTU systems.
+func WithLegend(text string) Option {
+	return func(o *options) {
+		o.legend = text
+	}
+}
+
+// Code contains an encoded QR code ready to be rendered.
+type Code struct {
+	url     string
+	modules [][]bool
+	opts    *options
+}
+
+// New encodes the provided URL as a QR code.
+func New(url string, opts ...Option) (*Code, error) {
+	o := &options{
+		size:   DefaultSize,
+		dpi:    DefaultDPI,
+		legend: LegendVerifactu,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.size < MinSize || o.size > MaxSize {
+		return nil, fmt.Errorf("%w: %.1fmm is outside %.0f-%.0fmm", ErrSize, o.size, MinSize, MaxSize)
+	}
+	if o.dpi <= 0 {
+		return nil, fmt.Errorf("%w: dpi must be positive", ErrSize)
+	}
+	q, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("qr: encoding url: %w", err)
+	}
+	q.DisableBorder = true
+	return &Code{
+		url:     url,
+		modules: q.Bitmap(),
+		opts:    o,
+	}, nil
+}
+
+// FromRegistration prepares the QR code of an invoice registration for the
+// given environment and mode. Codes for NO VERI*FACTU systems have no legend
+// unless one is provided explicitly.
+func FromRegistration(reg *verifactu.InvoiceRegistration, env verifactu.Environment, mode verifactu.Mode, opts ...Option) (*Code, error) {
+	return New(reg.URL(env, mode), modeOptions(mode, opts)...)
+}
+
+// FromEnvelope prepares the QR code using the URL stamped in the envelope
+// when the invoice was registered. The legend depends on the mode of the
+// validation service in the URL.
+func FromEnvelope(env *gobl.Envelope, opts ...Option) (*Code, error) {
+	var st *head.Stamp
+	if env.Head != nil {
+		st = env.Head.GetStamp(vf.StampQR)
+	}
+	if st == nil || st.Value == "" {
+		return nil, ErrNoStamp
+	}
+	d, err := verifactu.ParseQRURL(st.Value)
+	if err != nil {
+		return nil, fmt.Errorf("qr: %w", err)
+	}
+	return New(st.Value, modeOptions(d.Mode, opts)...)
+}
+
+// modeOptions removes the legend by default for NO VERI*FACTU systems.
+func modeOptions(mode verifactu.Mode, opts []Option) []Option {
+	if mode != verifactu.ModeNoVerifactu {
+		return opts
+	}
+	return append([]Option{WithLegend("")}, opts...)
+}
+
+// URL returns the encoded URL.
+func (c *Code) URL() string {
+	return c.url
+}
+
+// layout describes the position of each element in module units.
+type layout struct {
+	symbol int     // modules in each side of the symbol
+	width  int     // total width, including the quiet zone
+	height int     // total height, including the legend
+	top    int     // offset of the symbol from the top
+	font   float64 // legend font size, zero when there is no legend
+	mm     float64 // size of a module in millimetres
+}
+
+func (c *Code) layout() *layout {
+	n := len(c.modules)
+	l := &layout{
+		symbol: n,
+		width:  n + 2*QuietZone,
+		height: n + 2*QuietZone,
+		top:    QuietZone,
+		mm:     c.opts.size / float64(n),
+	}
+	if c.opts.legend != "" {
+		l.font = legendSize / l.mm
+		// leave a module either side of the text
+		if w := float64(l.width-2) / textWidth(c.opts.legend); w < l.font {
+			l.font = w
+		}
+		band := int(math.Ceil(l.font * 1.5))
+		l.height += band
+		l.top += band
+	}
+	return l
+}
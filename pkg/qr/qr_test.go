@@ -0,0 +1,143 @@
+package qr_test
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/qr"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testURL = "https://prewww2.aeat.es/wlpl/TIKE-CONT/ValidarQR?nif=89890001K&numserie=12345678-G33&fecha=01-09-2024&importe=241.40"
+
+func TestNew(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		c, err := qr.New(testURL)
+		require.NoError(t, err)
+		assert.Equal(t, testURL, c.URL())
+	})
+
+	t.Run("size limits", func(t *testing.T) {
+		_, err := qr.New(testURL, qr.WithSize(29.9))
+		assert.ErrorIs(t, err, qr.ErrSize)
+		_, err = qr.New(testURL, qr.WithSize(40.1))
+		assert.ErrorIs(t, err, qr.ErrSize)
+		_, err = qr.New(testURL, qr.WithSize(40))
+		assert.NoError(t, err)
+		_, err = qr.New(testURL, qr.WithDPI(0))
+		assert.ErrorIs(t, err, qr.ErrSize)
+	})
+}
+
+func TestFromEnvelope(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	vc, err := verifactu.New(verifactu.Software{}, verifactu.WithCurrentTime(ts))
+	require.NoError(t, err)
+
+	t.Run("stamped", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := vc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		c, err := qr.FromEnvelope(env)
+		require.NoError(t, err)
+		assert.Equal(t, reg.URL(verifactu.EnvironmentSandbox, verifactu.ModeVerifactu), c.URL())
+
+		c2, err := qr.FromRegistration(reg, verifactu.EnvironmentSandbox, verifactu.ModeVerifactu)
+		require.NoError(t, err)
+		assert.Equal(t, c.URL(), c2.URL())
+	})
+
+	t.Run("no verifactu", func(t *testing.T) {
+		nvc, err := verifactu.New(verifactu.Software{},
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithMode(verifactu.ModeNoVerifactu),
+		)
+		require.NoError(t, err)
+		env := test.LoadEnvelope("inv-base.json")
+		_, err = nvc.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		c, err := qr.FromEnvelope(env)
+		require.NoError(t, err)
+		assert.Contains(t, c.URL(), verifactu.QRURLSandboxNoVerifactu+"?")
+		assert.NotContains(t, string(c.SVG()), "<text")
+
+		c, err = qr.FromEnvelope(env, qr.WithLegend(qr.LegendVerifiable))
+		require.NoError(t, err)
+		assert.Contains(t, string(c.SVG()), "<text")
+	})
+
+	t.Run("missing stamp", func(t *testing.T) {
+		_, err := qr.FromEnvelope(test.LoadEnvelope("inv-base.json"))
+		assert.ErrorIs(t, err, qr.ErrNoStamp)
+	})
+}
+
+func TestPNG(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		c, err := qr.New(testURL, qr.WithLegend(""))
+		require.NoError(t, err)
+		data, err := c.PNG()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		b := img.Bounds()
+		assert.Equal(t, b.Dx(), b.Dy())
+
+		// symbol of at least 30mm at 300 dpi, plus the quiet zone
+		mm := float64(b.Dx()) / qr.DefaultDPI * 25.4
+		assert.Greater(t, mm, qr.MinSize)
+		assert.Less(t, mm, qr.MaxSize)
+
+		// quiet zone is blank
+		assert.Equal(t, color.GrayModel.Convert(color.White), color.GrayModel.Convert(img.At(1, 1)))
+	})
+
+	t.Run("legend", func(t *testing.T) {
+		c, err := qr.New(testURL)
+		require.NoError(t, err)
+		data, err := c.PNG()
+		require.NoError(t, err)
+		img, err := png.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		b := img.Bounds()
+		assert.Greater(t, b.Dy(), b.Dx())
+	})
+
+	t.Run("low resolution", func(t *testing.T) {
+		c, err := qr.New(testURL, qr.WithSize(40), qr.WithDPI(20))
+		require.NoError(t, err)
+		_, err = c.PNG()
+		assert.ErrorIs(t, err, qr.ErrSize)
+	})
+}
+
+func TestSVG(t *testing.T) {
+	t.Run("verifactu legend", func(t *testing.T) {
+		c, err := qr.New(testURL)
+		require.NoError(t, err)
+		out := string(c.SVG())
+		assert.Contains(t, out, `<svg xmlns="http://www.w3.org/2000/svg"`)
+		assert.Contains(t, out, ">VERI*FACTU</text>")
+	})
+
+	t.Run("long legend", func(t *testing.T) {
+		c, err := qr.New(testURL, qr.WithLegend(qr.LegendVerifiable), qr.WithSize(35))
+		require.NoError(t, err)
+		out := string(c.SVG())
+		assert.Contains(t, out, ">Factura verificable en la sede electrónica de la AEAT</text>")
+	})
+
+	t.Run("no legend", func(t *testing.T) {
+		c, err := qr.New(testURL, qr.WithLegend(""))
+		require.NoError(t, err)
+		out := string(c.SVG())
+		assert.NotContains(t, out, "<text")
+	})
+}
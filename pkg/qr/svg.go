@@ -0,0 +1,53 @@
+package qr
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// SVG renders the QR code as an SVG image sized in millimetres, with the
+// view box expressed in modules.
+func (c *Code) SVG() []byte {
+	l := c.layout()
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(buf,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%smm" height="%smm" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		formatFloat(float64(l.width)*l.mm), formatFloat(float64(l.height)*l.mm), l.width, l.height,
+	)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", l.width, l.height)
+	if l.font > 0 {
+		buf.WriteString(`<text x="`)
+		buf.WriteString(formatFloat(float64(l.width) / 2))
+		buf.WriteString(`" y="`)
+		buf.WriteString(formatFloat(float64(l.top-QuietZone) - l.font*0.25))
+		buf.WriteString(`" font-family="Go, Arial, Helvetica, sans-serif" font-size="`)
+		buf.WriteString(formatFloat(l.font))
+		buf.WriteString(`" text-anchor="middle" fill="#000">`)
+		xml.EscapeText(buf, []byte(c.opts.legend)) //nolint:errcheck
+		buf.WriteString("</text>\n")
+	}
+	buf.WriteString(`<path fill="#000" d="`)
+	for y, row := range c.modules {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			// join horizontal runs of dark modules
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(buf, "M%d %dh%dv1h-%dz", QuietZone+start, l.top+y, x-start, x-start)
+		}
+	}
+	buf.WriteString("\"/>\n</svg>\n")
+	return buf.Bytes()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*1000)/1000, 'f', -1, 64)
+}
@@ -0,0 +1,202 @@
+package verifactutest
+
+import (
+	"encoding/xml"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+)
+
+// Response XML namespaces
+const (
+	nsEnv    = "http://schemas.xmlsoap.org/soap/envelope/"
+	nsTikR   = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/RespuestaSuministro.xsd"
+	nsTik    = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/SuministroInformacion.xsd"
+	nsTikLRR = "https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/RespuestaConsultaLR.xsd"
+)
+
+// --- Incoming requests, matched by local name ---
+
+type requestEnvelope struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		Register *registerRequest `xml:"RegFactuSistemaFacturacion"`
+		Query    *queryRequest    `xml:"ConsultaFactuSistemaFacturacion"`
+	} `xml:"Body"`
+}
+
+type requestParty struct {
+	Name string `xml:"NombreRazon"`
+	NIF  string `xml:"NIF"`
+}
+
+type registerRequest struct {
+	Header struct {
+		Issuer         requestParty  `xml:"ObligadoEmision"`
+		Representative *requestParty `xml:"Representante"`
+	} `xml:"Cabecera"`
+	Lines []*requestLine `xml:"RegistroFactura"`
+}
+
+type requestLine struct {
+	Registration *requestRegistration `xml:"RegistroAlta"`
+	Cancellation *requestCancellation `xml:"RegistroAnulacion"`
+}
+
+type requestRegistration struct {
+	ID struct {
+		Issuer string `xml:"IDEmisorFactura"`
+		Code   string `xml:"NumSerieFactura"`
+		Date   string `xml:"FechaExpedicionFactura"`
+	} `xml:"IDFactura"`
+	Ref                string `xml:"RefExterna"`
+	Name               string `xml:"NombreRazonEmisor"`
+	Amendment          string `xml:"Subsanacion"`
+	PreviouslyRejected string `xml:"RechazoPrevio"`
+	Type               string `xml:"TipoFactura"`
+	Description        string `xml:"DescripcionOperacion"`
+	TaxTotal           string `xml:"CuotaTotal"`
+	Total              string `xml:"ImporteTotal"`
+	GeneratedAt        string `xml:"FechaHoraHusoGenRegistro"`
+	FingerprintType    string `xml:"TipoHuella"`
+	Fingerprint        string `xml:"Huella"`
+}
+
+type requestCancellation struct {
+	ID struct {
+		Issuer string `xml:"IDEmisorFacturaAnulada"`
+		Code   string `xml:"NumSerieFacturaAnulada"`
+		Date   string `xml:"FechaExpedicionFacturaAnulada"`
+	} `xml:"IDFactura"`
+	Ref                string `xml:"RefExterna"`
+	NoPrevious         string `xml:"SinRegistroPrevio"`
+	PreviouslyRejected string `xml:"RechazoPrevio"`
+	GeneratedAt        string `xml:"FechaHoraHusoGenRegistro"`
+	Fingerprint        string `xml:"Huella"`
+}
+
+type queryRequest struct {
+	Header struct {
+		Issuer *requestParty `xml:"ObligadoEmision"`
+	} `xml:"Cabecera"`
+	Filter struct {
+		Period struct {
+			Year   string `xml:"Ejercicio"`
+			Period string `xml:"Periodo"`
+		} `xml:"PeriodoImputacion"`
+		Code string `xml:"NumSerieFactura"`
+		Date struct {
+			Date string `xml:"FechaExpedicionFactura"`
+		} `xml:"FechaExpedicionFactura"`
+		Ref string `xml:"RefExterna"`
+	} `xml:"FiltroConsulta"`
+	Extra struct {
+		ShowName string `xml:"MostrarNombreRazonEmisor"`
+	} `xml:"DatosAdicionalesRespuesta"`
+}
+
+// --- Outgoing responses, with explicit prefixes ---
+
+type responseEnvelope struct {
+	XMLName xml.Name `xml:"env:Envelope"`
+	XMLNs   string   `xml:"xmlns:env,attr"`
+	Body    struct {
+		Fault    *responseFault    `xml:"env:Fault,omitempty"`
+		Register *registerResponse `xml:"tikR:RespuestaRegFactuSistemaFacturacion,omitempty"`
+		Query    *queryResponse    `xml:"tikLRRC:RespuestaConsultaFactuSistemaFacturacion,omitempty"`
+	} `xml:"env:Body"`
+}
+
+type responseFault struct {
+	Code    string `xml:"faultcode"`
+	Message string `xml:"faultstring"`
+}
+
+type responseParty struct {
+	Name string `xml:"tik:NombreRazon"`
+	NIF  string `xml:"tik:NIF"`
+}
+
+type responseID struct {
+	Issuer string `xml:"tik:IDEmisorFactura"`
+	Code   string `xml:"tik:NumSerieFactura"`
+	Date   string `xml:"tik:FechaExpedicionFactura"`
+}
+
+type registerResponse struct {
+	TikR         string `xml:"xmlns:tikR,attr"`
+	Tik          string `xml:"xmlns:tik,attr"`
+	CSV          string `xml:"tikR:CSV,omitempty"`
+	Presentation *struct {
+		NIF       string `xml:"tik:NIFPresentador"`
+		Timestamp string `xml:"tik:TimestampPresentacion"`
+	} `xml:"tikR:DatosPresentacion,omitempty"`
+	Header struct {
+		Issuer         responseParty  `xml:"tik:ObligadoEmision"`
+		Representative *responseParty `xml:"tik:Representante,omitempty"`
+	} `xml:"tikR:Cabecera"`
+	Wait   int                        `xml:"tikR:TiempoEsperaEnvio"`
+	Status verifactu.SubmissionStatus `xml:"tikR:EstadoEnvio"`
+	Lines  []*responseLine            `xml:"tikR:RespuestaLinea"`
+}
+
+type responseLine struct {
+	ID        responseID `xml:"tikR:IDFactura"`
+	Operation struct {
+		Type               verifactu.OpType `xml:"tik:TipoOperacion"`
+		Amendment          string           `xml:"tik:Subsanacion,omitempty"`
+		PreviouslyRejected string           `xml:"tik:RechazoPrevio,omitempty"`
+		NoPrevious         string           `xml:"tik:SinRegistroPrevio,omitempty"`
+	} `xml:"tikR:Operacion"`
+	Ref         string `xml:"tikR:RefExterna,omitempty"`
+	Status      string `xml:"tikR:EstadoRegistro"`
+	Code        string `xml:"tikR:CodigoErrorRegistro,omitempty"`
+	Description string `xml:"tikR:DescripcionErrorRegistro,omitempty"`
+	Duplicated  *struct {
+		ID          string `xml:"tik:IdPeticionRegistroDuplicado"`
+		Status      string `xml:"tik:EstadoRegistroDuplicado"`
+		Code        string `xml:"tik:CodigoErrorRegistro,omitempty"`
+		Description string `xml:"tik:DescripcionErrorRegistro,omitempty"`
+	} `xml:"tikR:RegistroDuplicado,omitempty"`
+}
+
+type queryResponse struct {
+	TikLRRC string `xml:"xmlns:tikLRRC,attr"`
+	Tik     string `xml:"xmlns:tik,attr"`
+	Header  struct {
+		Version string        `xml:"tik:IDVersion"`
+		Issuer  responseParty `xml:"tik:ObligadoEmision"`
+	} `xml:"tikLRRC:Cabecera"`
+	Period struct {
+		Year   string `xml:"tikLRRC:Ejercicio"`
+		Period string `xml:"tikLRRC:Periodo"`
+	} `xml:"tikLRRC:PeriodoImputacion"`
+	Pagination string         `xml:"tikLRRC:IndicadorPaginacion"`
+	Result     string         `xml:"tikLRRC:ResultadoConsulta"`
+	Records    []*queryRecord `xml:"tikLRRC:RegistroRespuestaConsultaFactuSistemaFacturacion"`
+}
+
+type queryRecord struct {
+	ID   responseID `xml:"tikLRRC:IDFactura"`
+	Data struct {
+		Name            string `xml:"tikLRRC:NombreRazonEmisor,omitempty"`
+		Ref             string `xml:"tikLRRC:RefExterna,omitempty"`
+		Type            string `xml:"tikLRRC:TipoFactura,omitempty"`
+		Description     string `xml:"tikLRRC:DescripcionOperacion,omitempty"`
+		TaxTotal        string `xml:"tikLRRC:CuotaTotal,omitempty"`
+		Total           string `xml:"tikLRRC:ImporteTotal,omitempty"`
+		GeneratedAt     string `xml:"tikLRRC:FechaHoraHusoGenRegistro,omitempty"`
+		FingerprintType string `xml:"tikLRRC:TipoHuella,omitempty"`
+		Fingerprint     string `xml:"tikLRRC:Huella,omitempty"`
+	} `xml:"tikLRRC:DatosRegistroFacturacion"`
+	Presentation struct {
+		NIF       string `xml:"tik:NIFPresentador"`
+		Timestamp string `xml:"tik:TimestampPresentacion"`
+		RequestID string `xml:"tik:IdPeticion"`
+	} `xml:"tikLRRC:DatosPresentacion"`
+	State struct {
+		Modified    string `xml:"tikLRRC:TimestampUltimaModificacion"`
+		Status      string `xml:"tikLRRC:EstadoRegistro"`
+		Code        string `xml:"tikLRRC:CodigoErrorRegistro,omitempty"`
+		Description string `xml:"tikLRRC:DescripcionErrorRegistro,omitempty"`
+	} `xml:"tikLRRC:EstadoRegistro"`
+}
@@ -0,0 +1,558 @@
+// Package verifactutest provides a local stand-in for the AEAT VeriFactu
+// SOAP service so that clients can be tested end-to-end without access to
+// the agency's sandbox.
+package verifactutest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test/schema"
+	"github.com/invopop/gobl/i18n"
+	"github.com/lestrrat-go/libxml2"
+	"github.com/lestrrat-go/libxml2/xsd"
+)
+
+// Status of individual records as stored by the server and reported in the
+// EstadoRegistro of query answers and duplicate details. These differ from
+// the EstadoRegistro values used in the lines of submission responses, such
+// as verifactu.StatusCorrect.
+const (
+	RecordCorrect            = "Correcta"
+	RecordAcceptedWithErrors = "AceptadaConErrores"
+	RecordCancelled          = "Anulada"
+)
+
+// Error codes raised by the server itself.
+const (
+	CodeSchema           = "4102"
+	CodeDuplicate        = "3000"
+	CodeAlreadyCancelled = "3001"
+	CodeNotFound         = "3002"
+)
+
+// defaultWait is the number of seconds clients are asked to wait between
+// submissions, matching the AEAT's usual response.
+const defaultWait = 60
+
+// Record contains the details of a registration stored by the server
+// for an issuer.
+type Record struct {
+	IssuerNIF        string
+	Code             string
+	IssueDate        string
+	Ref              string
+	Name             string
+	Type             string
+	Description      string
+	TaxTotal         string
+	Total            string
+	GeneratedAt      string
+	FingerprintType  string
+	Fingerprint      string
+	Status           string
+	ErrorCode        string
+	ErrorDescription string
+	RequestID        string
+	Presenter        string
+	Presented        time.Time
+	Modified         time.Time
+}
+
+// Outcome can be used to force the result of the next record received with
+// a given invoice code, regardless of the content.
+type Outcome struct {
+	Status      string
+	Code        string
+	Description string
+}
+
+// Server is a local HTTP server that responds to VeriFactu requests in the
+// same way as the AEAT.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	schema   *xsd.Schema
+	now      func() time.Time
+	wait     int
+	faults   []*verifactu.Fault
+	outcomes map[string]*Outcome
+	records  map[string][]*Record
+	requests int
+}
+
+// Option is used to configure the server.
+type Option func(*Server)
+
+// WithWait sets the number of seconds returned in the TiempoEsperaEnvio
+// field of each response.
+func WithWait(seconds int) Option {
+	return func(s *Server) {
+		s.wait = seconds
+	}
+}
+
+// WithCurrentTime defines the time used for presentation timestamps.
+func WithCurrentTime(ts time.Time) Option {
+	return func(s *Server) {
+		s.now = func() time.Time { return ts }
+	}
+}
+
+var (
+	schemaOnce sync.Once
+	schemaDoc  *xsd.Schema
+	schemaErr  error
+)
+
+// NewServer starts a new server ready to receive requests. It will panic if
+// the bundled schemas cannot be loaded. Call Close once finished.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		now:      time.Now,
+		wait:     defaultWait,
+		outcomes: make(map[string]*Outcome),
+		records:  make(map[string][]*Record),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	schemaOnce.Do(func() {
+		schemaDoc, schemaErr = loadSchema()
+	})
+	if schemaErr != nil {
+		panic(fmt.Errorf("loading schema: %w", schemaErr))
+	}
+	s.schema = schemaDoc
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetWait updates the wait time returned in subsequent responses.
+func (s *Server) SetWait(seconds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wait = seconds
+}
+
+// FailNext will respond to the next request with the provided SOAP fault.
+func (s *Server) FailNext(f *verifactu.Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+// SetOutcome forces the result of the next record received with the provided
+// invoice code. Records with an "Incorrecto" status will not be stored.
+func (s *Server) SetOutcome(code string, o *Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outcomes[code] = o
+}
+
+// Records provides a copy of the records stored for the issuer's NIF.
+func (s *Server) Records(nif string) []*Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*Record, len(s.records[nif]))
+	for i, r := range s.records[nif] {
+		rc := *r
+		out[i] = &rc
+	}
+	return out
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.faults) > 0 {
+		f := s.faults[0]
+		s.faults = s.faults[1:]
+		s.writeFault(w, f)
+		return
+	}
+
+	if err := s.validate(body); err != nil {
+		s.writeFault(w, &verifactu.Fault{
+			Code:    "env:Client",
+			Message: fmt.Sprintf("Codigo[%s].%s %s", CodeSchema, description(CodeSchema), err.Error()),
+		})
+		return
+	}
+
+	req := new(requestEnvelope)
+	if err := xml.Unmarshal(body, req); err != nil {
+		s.writeFault(w, &verifactu.Fault{Code: "env:Client", Message: err.Error()})
+		return
+	}
+
+	res := newResponseEnvelope()
+	switch {
+	case req.Body.Register != nil:
+		res.Body.Register = s.register(req.Body.Register)
+	case req.Body.Query != nil:
+		res.Body.Query = s.query(req.Body.Query)
+	default:
+		s.writeFault(w, &verifactu.Fault{Code: "env:Client", Message: "operación no soportada"})
+		return
+	}
+	s.write(w, res)
+}
+
+func (s *Server) validate(body []byte) error {
+	doc, err := libxml2.Parse(body)
+	if err != nil {
+		return err
+	}
+	defer doc.Free()
+	if err := s.schema.Validate(doc); err != nil {
+		if verr, ok := err.(xsd.SchemaValidationError); ok && len(verr.Errors()) > 0 {
+			return verr.Errors()[0]
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Server) register(req *registerRequest) *registerResponse {
+	s.requests++
+	reqID := fmt.Sprintf("%020d", s.requests)
+	now := s.now()
+	presenter := req.Header.Issuer.NIF
+	if rep := req.Header.Representative; rep != nil {
+		presenter = rep.NIF
+	}
+
+	res := &registerResponse{
+		TikR: nsTikR,
+		Tik:  nsTik,
+		Wait: s.wait,
+	}
+	res.Header.Issuer = responseParty(req.Header.Issuer)
+	if rep := req.Header.Representative; rep != nil {
+		res.Header.Representative = &responseParty{Name: rep.Name, NIF: rep.NIF}
+	}
+
+	correct, incorrect := 0, 0
+	for _, line := range req.Lines {
+		var rl *responseLine
+		switch {
+		case line.Registration != nil:
+			rl = s.registerLine(line.Registration, reqID, presenter, now)
+		case line.Cancellation != nil:
+			rl = s.cancelLine(line.Cancellation, now)
+		default:
+			continue
+		}
+		switch rl.Status {
+		case verifactu.StatusCorrect:
+			correct++
+		case verifactu.StatusIncorrect:
+			incorrect++
+		}
+		res.Lines = append(res.Lines, rl)
+	}
+
+	switch {
+	case correct == len(res.Lines):
+		res.Status = verifactu.SubmissionCorrect
+	case incorrect == len(res.Lines):
+		res.Status = verifactu.SubmissionIncorrect
+	default:
+		res.Status = verifactu.SubmissionPartiallyCorrect
+	}
+
+	if res.Status != verifactu.SubmissionIncorrect {
+		res.CSV = fmt.Sprintf("A-%014X", s.requests)
+		res.Presentation = &struct {
+			NIF       string `xml:"tik:NIFPresentador"`
+			Timestamp string `xml:"tik:TimestampPresentacion"`
+		}{
+			NIF:       presenter,
+			Timestamp: formatTimestamp(now),
+		}
+	}
+	return res
+}
+
+func (s *Server) registerLine(reg *requestRegistration, reqID, presenter string, now time.Time) *responseLine {
+	rl := new(responseLine)
+	rl.ID = responseID(reg.ID)
+	rl.Operation.Type = verifactu.OpTypeRegistration
+	rl.Operation.Amendment = reg.Amendment
+	rl.Operation.PreviouslyRejected = reg.PreviouslyRejected
+	rl.Ref = reg.Ref
+
+	existing := s.find(reg.ID.Issuer, reg.ID.Code, reg.ID.Date)
+	amendment := reg.Amendment == "S"
+	switch {
+	case existing != nil && !amendment:
+		rejectLine(rl, CodeDuplicate)
+		rl.Duplicated = &struct {
+			ID          string `xml:"tik:IdPeticionRegistroDuplicado"`
+			Status      string `xml:"tik:EstadoRegistroDuplicado"`
+			Code        string `xml:"tik:CodigoErrorRegistro,omitempty"`
+			Description string `xml:"tik:DescripcionErrorRegistro,omitempty"`
+		}{
+			ID:          existing.RequestID,
+			Status:      existing.Status,
+			Code:        existing.ErrorCode,
+			Description: existing.ErrorDescription,
+		}
+		return rl
+	case existing == nil && amendment && reg.PreviouslyRejected != "X" && reg.PreviouslyRejected != "S":
+		rejectLine(rl, CodeNotFound)
+		return rl
+	}
+
+	rl.Status = verifactu.StatusCorrect
+	if s.applyOutcome(rl, reg.ID.Code) {
+		return rl
+	}
+
+	rec := existing
+	if rec == nil {
+		rec = &Record{
+			IssuerNIF: reg.ID.Issuer,
+			Code:      reg.ID.Code,
+			IssueDate: reg.ID.Date,
+			Presented: now,
+		}
+		s.records[rec.IssuerNIF] = append(s.records[rec.IssuerNIF], rec)
+	}
+	rec.Ref = reg.Ref
+	rec.Name = reg.Name
+	rec.Type = reg.Type
+	rec.Description = reg.Description
+	rec.TaxTotal = reg.TaxTotal
+	rec.Total = reg.Total
+	rec.GeneratedAt = reg.GeneratedAt
+	rec.FingerprintType = reg.FingerprintType
+	rec.Fingerprint = reg.Fingerprint
+	rec.Status = recordStatus(rl.Status)
+	rec.ErrorCode = rl.Code
+	rec.ErrorDescription = rl.Description
+	rec.RequestID = reqID
+	rec.Presenter = presenter
+	rec.Modified = now
+	return rl
+}
+
+func (s *Server) cancelLine(can *requestCancellation, now time.Time) *responseLine {
+	rl := new(responseLine)
+	rl.ID = responseID(can.ID)
+	rl.Operation.Type = verifactu.OpTypeCancellation
+	rl.Operation.PreviouslyRejected = can.PreviouslyRejected
+	rl.Operation.NoPrevious = can.NoPrevious
+	rl.Ref = can.Ref
+
+	existing := s.find(can.ID.Issuer, can.ID.Code, can.ID.Date)
+	switch {
+	case existing == nil && can.NoPrevious != "S":
+		rejectLine(rl, CodeNotFound)
+		return rl
+	case existing != nil && existing.Status == RecordCancelled:
+		rejectLine(rl, CodeAlreadyCancelled)
+		return rl
+	}
+
+	rl.Status = verifactu.StatusCorrect
+	if s.applyOutcome(rl, can.ID.Code) {
+		return rl
+	}
+
+	if existing == nil {
+		existing = &Record{
+			IssuerNIF: can.ID.Issuer,
+			Code:      can.ID.Code,
+			IssueDate: can.ID.Date,
+			Presented: now,
+		}
+		s.records[existing.IssuerNIF] = append(s.records[existing.IssuerNIF], existing)
+	}
+	existing.Status = RecordCancelled
+	existing.Modified = now
+	return rl
+}
+
+// applyOutcome updates the response line with any forced outcome, and returns
+// true if the record should not be stored.
+func (s *Server) applyOutcome(rl *responseLine, code string) bool {
+	o, ok := s.outcomes[code]
+	if !ok {
+		return false
+	}
+	delete(s.outcomes, code)
+	rl.Status = o.Status
+	rl.Code = o.Code
+	rl.Description = o.Description
+	return o.Status == verifactu.StatusIncorrect
+}
+
+func (s *Server) find(nif, code, date string) *Record {
+	for _, r := range s.records[nif] {
+		if r.Code == code && r.IssueDate == date {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *Server) query(req *queryRequest) *queryResponse {
+	res := &queryResponse{
+		TikLRRC:    nsTikLRR,
+		Tik:        nsTik,
+		Pagination: "N",
+		Result:     "SinDatos",
+	}
+	res.Header.Version = verifactu.CurrentVersion
+	res.Period.Year = req.Filter.Period.Year
+	res.Period.Period = req.Filter.Period.Period
+	if req.Header.Issuer == nil {
+		return res
+	}
+	res.Header.Issuer = responseParty(*req.Header.Issuer)
+
+	f := req.Filter
+	for _, r := range s.records[req.Header.Issuer.NIF] {
+		if !inPeriod(r.IssueDate, f.Period.Year, f.Period.Period) {
+			continue
+		}
+		if f.Code != "" && f.Code != r.Code {
+			continue
+		}
+		if f.Date.Date != "" && f.Date.Date != r.IssueDate {
+			continue
+		}
+		if f.Ref != "" && f.Ref != r.Ref {
+			continue
+		}
+		qr := new(queryRecord)
+		qr.ID = responseID{Issuer: r.IssuerNIF, Code: r.Code, Date: r.IssueDate}
+		if req.Extra.ShowName == "S" {
+			qr.Data.Name = r.Name
+		}
+		qr.Data.Ref = r.Ref
+		qr.Data.Type = r.Type
+		qr.Data.Description = r.Description
+		qr.Data.TaxTotal = r.TaxTotal
+		qr.Data.Total = r.Total
+		qr.Data.GeneratedAt = r.GeneratedAt
+		qr.Data.FingerprintType = r.FingerprintType
+		qr.Data.Fingerprint = r.Fingerprint
+		qr.Presentation.NIF = r.Presenter
+		qr.Presentation.Timestamp = formatTimestamp(r.Presented)
+		qr.Presentation.RequestID = r.RequestID
+		qr.State.Modified = formatTimestamp(r.Modified)
+		qr.State.Status = r.Status
+		qr.State.Code = r.ErrorCode
+		qr.State.Description = r.ErrorDescription
+		res.Records = append(res.Records, qr)
+	}
+	if len(res.Records) > 0 {
+		res.Result = "ConDatos"
+	}
+	return res
+}
+
+// writeFault responds with a SOAP fault and a 500 status code, as the AEAT
+// service does.
+func (s *Server) writeFault(w http.ResponseWriter, f *verifactu.Fault) {
+	res := newResponseEnvelope()
+	res.Body.Fault = &responseFault{Code: f.Code, Message: f.Message}
+	s.writeStatus(w, http.StatusInternalServerError, res)
+}
+
+func (s *Server) write(w http.ResponseWriter, res *responseEnvelope) {
+	s.writeStatus(w, http.StatusOK, res)
+}
+
+func (s *Server) writeStatus(w http.ResponseWriter, status int, res *responseEnvelope) {
+	data, err := xml.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(data)
+}
+
+func newResponseEnvelope() *responseEnvelope {
+	return &responseEnvelope{XMLNs: nsEnv}
+}
+
+func rejectLine(rl *responseLine, code string) {
+	rl.Status = verifactu.StatusIncorrect
+	rl.Code = code
+	rl.Description = description(code)
+}
+
+// description provides the Spanish description of the code, as used by
+// the AEAT in responses.
+func description(code string) string {
+	return verifactu.LookupErrorCode(code).Description.In(i18n.ES)
+}
+
+// recordStatus maps the status of a submission line to the status stored
+// for the record.
+func recordStatus(st string) string {
+	if st == verifactu.StatusAcceptedWithErrors {
+		return RecordAcceptedWithErrors
+	}
+	return RecordCorrect
+}
+
+// inPeriod checks if the issue date, in the DD-MM-YYYY format, is inside
+// the provided year and month.
+func inPeriod(date, year, period string) bool {
+	parts := strings.Split(date, "-")
+	return len(parts) == 3 && parts[2] == year && parts[1] == period
+}
+
+func formatTimestamp(ts time.Time) string {
+	return ts.Format("2006-01-02T15:04:05-07:00")
+}
+
+// loadSchema writes the embedded schema documents to a temporary directory
+// so that libxml2 can resolve the imports between them.
+func loadSchema() (*xsd.Schema, error) {
+	dir, err := os.MkdirTemp("", "verifactutest")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	files, err := schema.FS.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		data, err := schema.FS.ReadFile(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.Name()), data, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return xsd.ParseFromFile(filepath.Join(dir, schema.Main))
+}
@@ -0,0 +1,197 @@
+package verifactutest_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const queryRequest = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:con="https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/ConsultaLR.xsd" xmlns:sum="https://www2.agenciatributaria.gob.es/static_files/common/internet/dep/aplicaciones/es/aeat/tike/cont/ws/SuministroInformacion.xsd">
+<soapenv:Body>
+<con:ConsultaFactuSistemaFacturacion>
+<con:Cabecera>
+<sum:IDVersion>1.0</sum:IDVersion>
+<sum:ObligadoEmision><sum:NombreRazon>Provide One S.L.</sum:NombreRazon><sum:NIF>B85905495</sum:NIF></sum:ObligadoEmision>
+</con:Cabecera>
+<con:FiltroConsulta>
+<con:PeriodoImputacion><sum:Ejercicio>2024</sum:Ejercicio><sum:Periodo>11</sum:Periodo></con:PeriodoImputacion>
+</con:FiltroConsulta>
+</con:ConsultaFactuSistemaFacturacion>
+</soapenv:Body>
+</soapenv:Envelope>`
+
+type queryResponse struct {
+	Body struct {
+		Response struct {
+			Result  string `xml:"ResultadoConsulta"`
+			Records []struct {
+				Code  string `xml:"IDFactura>NumSerieFactura"`
+				State string `xml:"EstadoRegistro>EstadoRegistro"`
+			} `xml:"RegistroRespuestaConsultaFactuSistemaFacturacion"`
+		} `xml:"RespuestaConsultaFactuSistemaFacturacion"`
+	} `xml:"Body"`
+}
+
+func TestServer(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	vc, err := verifactu.New(verifactu.Software{
+		NombreRazon:                 "My Software",
+		NIF:                         "12345678A",
+		NombreSistemaInformatico:    "My Software",
+		IdSistemaInformatico:        "A1",
+		Version:                     "1.0",
+		NumeroInstalacion:           "12345678A",
+		TipoUsoPosibleSoloVerifactu: "S",
+		TipoUsoPosibleMultiOT:       "S",
+		IndicadorMultiplesOT:        "N",
+	}, verifactu.WithCurrentTime(ts))
+	require.NoError(t, err)
+
+	env, inv := test.LoadInvoice("inv-base.json")
+	reg, err := vc.RegisterInvoice(env, nil)
+	require.NoError(t, err)
+	can, err := vc.CancelInvoice(env, reg.ChainData())
+	require.NoError(t, err)
+
+	send := func(t *testing.T, srv *verifactutest.Server, lines ...any) *verifactu.EnvelopeResponse {
+		t.Helper()
+		ir, err := vc.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		for _, l := range lines {
+			switch doc := l.(type) {
+			case *verifactu.InvoiceRegistration:
+				ir.AddRegistration(doc)
+			case *verifactu.InvoiceCancellation:
+				ir.AddCancellation(doc)
+			}
+		}
+		data, err := ir.Envelop().Bytes()
+		require.NoError(t, err)
+		return post(t, srv, data)
+	}
+
+	t.Run("registration and duplicate", func(t *testing.T) {
+		srv := verifactutest.NewServer(verifactutest.WithCurrentTime(ts))
+		defer srv.Close()
+
+		res := send(t, srv, reg)
+		ir := res.Body.InvoiceResponse
+		require.NotNil(t, ir)
+		assert.Equal(t, verifactu.SubmissionCorrect, ir.Status)
+		assert.Equal(t, 60, ir.Wait)
+		require.Len(t, ir.Lines, 1)
+		assert.NoError(t, ir.Lines[0].Error())
+		assert.Equal(t, "SAMPLE-004", ir.Lines[0].ID.Code)
+
+		recs := srv.Records("B85905495")
+		require.Len(t, recs, 1)
+		assert.Equal(t, reg.Huella, recs[0].Fingerprint)
+		assert.Equal(t, verifactutest.RecordCorrect, recs[0].Status)
+
+		res = send(t, srv, reg)
+		ir = res.Body.InvoiceResponse
+		require.NotNil(t, ir)
+		assert.Equal(t, verifactu.SubmissionIncorrect, ir.Status)
+		require.Len(t, ir.Lines, 1)
+		assert.ErrorIs(t, ir.Lines[0].Error(), verifactu.ErrDuplicate)
+		require.NotNil(t, ir.Lines[0].Duplicated)
+		assert.Equal(t, recs[0].RequestID, ir.Lines[0].Duplicated.ID)
+		assert.Equal(t, "Correcta", ir.Lines[0].Duplicated.Status)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+
+		res := send(t, srv, can)
+		require.NotNil(t, res.Body.InvoiceResponse)
+		assert.Equal(t, verifactutest.CodeNotFound, res.Body.InvoiceResponse.Lines[0].Code)
+
+		res = send(t, srv, reg, can)
+		ir := res.Body.InvoiceResponse
+		require.NotNil(t, ir)
+		assert.Equal(t, verifactu.SubmissionCorrect, ir.Status)
+		recs := srv.Records("B85905495")
+		require.Len(t, recs, 1)
+		assert.Equal(t, verifactutest.RecordCancelled, recs[0].Status)
+
+		res = send(t, srv, can)
+		assert.Equal(t, verifactutest.CodeAlreadyCancelled, res.Body.InvoiceResponse.Lines[0].Code)
+	})
+
+	t.Run("forced outcome and wait", func(t *testing.T) {
+		srv := verifactutest.NewServer(verifactutest.WithWait(30))
+		defer srv.Close()
+		srv.SetOutcome("SAMPLE-004", &verifactutest.Outcome{
+			Status:      verifactu.StatusAcceptedWithErrors,
+			Code:        "2000",
+			Description: "El cálculo de la huella suministrada es incorrecta.",
+		})
+
+		res := send(t, srv, reg)
+		ir := res.Body.InvoiceResponse
+		require.NotNil(t, ir)
+		assert.Equal(t, verifactu.SubmissionPartiallyCorrect, ir.Status)
+		assert.Equal(t, 30, ir.Wait)
+		assert.ErrorIs(t, ir.Lines[0].Error(), verifactu.ErrWarning)
+		recs := srv.Records("B85905495")
+		require.Len(t, recs, 1)
+		assert.Equal(t, verifactutest.RecordAcceptedWithErrors, recs[0].Status)
+	})
+
+	t.Run("faults", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+		srv.FailNext(&verifactu.Fault{Code: "env:Server", Message: "Codigo[999].Servicio no disponible"})
+
+		res := send(t, srv, reg)
+		require.NotNil(t, res.Body.Fault)
+		assert.Equal(t, "env:Server", res.Body.Fault.Code)
+
+		res = post(t, srv, []byte(`<?xml version="1.0"?><soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><sum:RegFactuSistemaFacturacion xmlns:sum="`+verifactu.SUM+`"/></soapenv:Body></soapenv:Envelope>`))
+		require.NotNil(t, res.Body.Fault)
+		assert.Contains(t, res.Body.Fault.Message, "Codigo[4102]")
+	})
+
+	t.Run("query", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		defer srv.Close()
+		send(t, srv, reg)
+
+		resp, err := http.Post(srv.URL, "text/xml", bytes.NewBufferString(queryRequest))
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+		out := new(queryResponse)
+		require.NoError(t, xml.NewDecoder(resp.Body).Decode(out))
+		assert.Equal(t, "ConDatos", out.Body.Response.Result)
+		require.Len(t, out.Body.Response.Records, 1)
+		assert.Equal(t, "SAMPLE-004", out.Body.Response.Records[0].Code)
+		assert.Equal(t, verifactutest.RecordCorrect, out.Body.Response.Records[0].State)
+		assert.Equal(t, "Correcta", out.Body.Response.Records[0].State)
+	})
+}
+
+func post(t *testing.T, srv *verifactutest.Server, data []byte) *verifactu.EnvelopeResponse {
+	t.Helper()
+	resp, err := http.Post(srv.URL, "text/xml", bytes.NewReader(data))
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	out := new(verifactu.EnvelopeResponse)
+	require.NoError(t, xml.NewDecoder(resp.Body).Decode(out))
+	if out.Body.Fault != nil {
+		require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	} else {
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	return out
+}
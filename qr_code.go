@@ -1,24 +1,172 @@
 package verifactu
 
 import (
-	"fmt"
 	"net/url"
+	"strings"
+	"time"
+
+	"github.com/invopop/gobl/num"
+)
+
+// Mode defines how the invoicing system operates, which determines the
+// service used to validate the QR codes printed on invoices.
+type Mode string
+
+// Supported operating modes
+const (
+	// ModeVerifactu is used by systems that send every record to the AEAT
+	// as it is generated.
+	ModeVerifactu Mode = "verifactu"
+	// ModeNoVerifactu is used by systems that keep the signed records and
+	// event log locally, to be provided on request.
+	ModeNoVerifactu Mode = "no-verifactu"
 )
 
+// Base URLs of the QR code validation services.
 const (
-	testURL = "https://prewww2.aeat.es/wlpl/TIKE-CONT/ValidarQR?"
-	prodURL = "https://www2.agenciatributaria.gob.es/wlpl/TIKE-CONT/ValidarQR?"
+	QRURLProduction            = "https://www2.agenciatributaria.gob.es/wlpl/TIKE-CONT/ValidarQR"
+	QRURLProductionNoVerifactu = "https://www2.agenciatributaria.gob.es/wlpl/TIKE-CONT/ValidarQRNoVerifactu"
+	QRURLSandbox               = "https://prewww2.aeat.es/wlpl/TIKE-CONT/ValidarQR"
+	QRURLSandboxNoVerifactu    = "https://prewww2.aeat.es/wlpl/TIKE-CONT/ValidarQRNoVerifactu"
 )
 
-// generateURL generates the encoded URL code with parameters.
-func (r *InvoiceRegistration) generateURL(production bool) string {
-	nif := url.QueryEscape(r.IDFactura.IDEmisorFactura)
-	numSerie := url.QueryEscape(r.IDFactura.NumSerieFactura)
-	fecha := url.QueryEscape(r.IDFactura.FechaExpedicionFactura)
-	importe := url.QueryEscape(r.ImporteTotal.String())
+// QR code URL parameters, in the order they are included.
+const (
+	qrParamNIF      = "nif"
+	qrParamNumSerie = "numserie"
+	qrParamFecha    = "fecha"
+	qrParamImporte  = "importe"
+)
+
+const qrDateFormat = "02-01-2006"
+
+// WithMode defines the operating mode of the invoicing system. Clients use
+// ModeVerifactu by default.
+func WithMode(mode Mode) Option {
+	return func(c *Client) {
+		c.mode = mode
+	}
+}
+
+// Mode returns the operating mode of the client.
+func (c *Client) Mode() Mode {
+	if c.mode == "" {
+		return ModeVerifactu
+	}
+	return c.mode
+}
+
+// QRData contains the invoice details encoded in the QR code URL, alongside
+// the environment and mode that determine the validation service.
+type QRData struct {
+	Environment Environment
+	Mode        Mode
+	NIF         string
+	NumSerie    string
+	Fecha       string // dd-mm-yyyy
+	Importe     num.Amount
+}
+
+// QRData provides the details to include in the registration's QR code.
+func (r *InvoiceRegistration) QRData(env Environment, mode Mode) *QRData {
+	d := &QRData{
+		Environment: env,
+		Mode:        mode,
+		Importe:     r.ImporteTotal,
+	}
+	if r.IDFactura != nil {
+		d.NIF = r.IDFactura.IDEmisorFactura
+		d.NumSerie = r.IDFactura.NumSerieFactura
+		d.Fecha = r.IDFactura.FechaExpedicionFactura
+	}
+	return d
+}
+
+// URL provides the validation URL for the registration in the given
+// environment and mode, as included in the QR code and the envelope's QR
+// stamp.
+func (r *InvoiceRegistration) URL(env Environment, mode Mode) string {
+	return r.QRData(env, mode).URL()
+}
+
+// QRURL provides the validation URL of the registration using the client's
+// environment and mode, so that it may be printed again later.
+func (c *Client) QRURL(reg *InvoiceRegistration) string {
+	return reg.URL(c.env, c.Mode())
+}
+
+// URL generates the encoded URL with the parameters in the order defined by
+// the AEAT.
+func (d *QRData) URL() string {
+	return qrBaseURL(d.Environment, d.Mode) + "?" +
+		qrParamNIF + "=" + url.QueryEscape(d.NIF) +
+		"&" + qrParamNumSerie + "=" + url.QueryEscape(d.NumSerie) +
+		"&" + qrParamFecha + "=" + url.QueryEscape(d.Fecha) +
+		"&" + qrParamImporte + "=" + url.QueryEscape(d.Importe.String())
+}
+
+// Matches returns true if the data identifies the registration and its
+// total amount.
+func (d *QRData) Matches(reg *InvoiceRegistration) bool {
+	if reg == nil || reg.IDFactura == nil {
+		return false
+	}
+	return d.NIF == reg.IDFactura.IDEmisorFactura &&
+		d.NumSerie == reg.IDFactura.NumSerieFactura &&
+		d.Fecha == reg.IDFactura.FechaExpedicionFactura &&
+		d.Importe.Equals(reg.ImporteTotal)
+}
 
-	if production {
-		return fmt.Sprintf("%s&nif=%s&numserie=%s&fecha=%s&importe=%s", prodURL, nif, numSerie, fecha, importe)
+// ParseQRURL extracts the details from a QR code validation URL, checking
+// it points to one of the AEAT services and contains all the parameters.
+func ParseQRURL(raw string) (*QRData, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, ErrValidation.WithMessage("invalid QR URL").WithCause(err)
+	}
+	d := new(QRData)
+	switch u.Scheme + "://" + strings.ToLower(u.Host) + u.Path {
+	case QRURLProduction:
+		d.Environment, d.Mode = EnvironmentProduction, ModeVerifactu
+	case QRURLProductionNoVerifactu:
+		d.Environment, d.Mode = EnvironmentProduction, ModeNoVerifactu
+	case QRURLSandbox:
+		d.Environment, d.Mode = EnvironmentSandbox, ModeVerifactu
+	case QRURLSandboxNoVerifactu:
+		d.Environment, d.Mode = EnvironmentSandbox, ModeNoVerifactu
+	default:
+		return nil, ErrValidation.WithMessage("unknown QR URL service")
+	}
+
+	q := u.Query()
+	for _, p := range []string{qrParamNIF, qrParamNumSerie, qrParamFecha, qrParamImporte} {
+		if q.Get(p) == "" {
+			return nil, ErrValidation.WithMessage("QR URL missing " + p)
+		}
+	}
+	d.NIF = q.Get(qrParamNIF)
+	d.NumSerie = q.Get(qrParamNumSerie)
+	d.Fecha = q.Get(qrParamFecha)
+	if _, err := time.Parse(qrDateFormat, d.Fecha); err != nil {
+		return nil, ErrValidation.WithMessage("invalid QR URL fecha").WithCause(err)
+	}
+	d.Importe, err = num.AmountFromString(q.Get(qrParamImporte))
+	if err != nil {
+		return nil, ErrValidation.WithMessage("invalid QR URL importe").WithCause(err)
+	}
+	return d, nil
+}
+
+func qrBaseURL(env Environment, mode Mode) string {
+	noVerifactu := mode == ModeNoVerifactu
+	if env == EnvironmentProduction {
+		if noVerifactu {
+			return QRURLProductionNoVerifactu
+		}
+		return QRURLProduction
+	}
+	if noVerifactu {
+		return QRURLSandboxNoVerifactu
 	}
-	return fmt.Sprintf("%snif=%s&numserie=%s&fecha=%s&importe=%s", testURL, nif, numSerie, fecha, importe)
+	return QRURLSandbox
 }
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/invopop/gobl/num"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGenerateCodes(t *testing.T) {
@@ -52,10 +54,80 @@ func TestGenerateCodes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tt.doc.generateURL(false)
+			got := tt.doc.URL(EnvironmentSandbox, ModeVerifactu)
 			if got != tt.expected {
 				t.Errorf("got %v, want %v", got, tt.expected)
 			}
 		})
 	}
 }
+
+func TestQRURLModes(t *testing.T) {
+	reg := &InvoiceRegistration{
+		IDFactura: &IDFactura{
+			IDEmisorFactura:        "89890001K",
+			NumSerieFactura:        "12345678-G33",
+			FechaExpedicionFactura: "01-09-2024",
+		},
+		ImporteTotal: num.MakeAmount(24140, 2),
+	}
+	params := "?nif=89890001K&numserie=12345678-G33&fecha=01-09-2024&importe=241.40"
+
+	tests := []struct {
+		env      Environment
+		mode     Mode
+		expected string
+	}{
+		{EnvironmentProduction, ModeVerifactu, QRURLProduction + params},
+		{EnvironmentProduction, ModeNoVerifactu, QRURLProductionNoVerifactu + params},
+		{EnvironmentSandbox, ModeVerifactu, QRURLSandbox + params},
+		{EnvironmentSandbox, ModeNoVerifactu, QRURLSandboxNoVerifactu + params},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.env)+"/"+string(tt.mode), func(t *testing.T) {
+			got := reg.URL(tt.env, tt.mode)
+			assert.Equal(t, tt.expected, got)
+
+			d, err := ParseQRURL(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.env, d.Environment)
+			assert.Equal(t, tt.mode, d.Mode)
+			assert.True(t, d.Matches(reg))
+			assert.Equal(t, got, d.URL())
+		})
+	}
+}
+
+func TestParseQRURL(t *testing.T) {
+	t.Run("special characters", func(t *testing.T) {
+		reg := &InvoiceRegistration{
+			IDFactura: &IDFactura{
+				IDEmisorFactura:        "A12 345&67",
+				NumSerieFactura:        "SERIE/2023",
+				FechaExpedicionFactura: "01-09-2024",
+			},
+			ImporteTotal: num.MakeAmount(123456, 2),
+		}
+		d, err := ParseQRURL(reg.URL(EnvironmentProduction, ModeVerifactu))
+		require.NoError(t, err)
+		assert.Equal(t, "A12 345&67", d.NIF)
+		assert.Equal(t, "SERIE/2023", d.NumSerie)
+		assert.True(t, d.Matches(reg))
+
+		other := *reg
+		other.ImporteTotal = num.MakeAmount(123457, 2)
+		assert.False(t, d.Matches(&other))
+		assert.False(t, d.Matches(nil))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := ParseQRURL("https://example.com/ValidarQR?nif=89890001K&numserie=1&fecha=01-09-2024&importe=1.00")
+		assert.ErrorIs(t, err, ErrValidation)
+		_, err = ParseQRURL(QRURLSandbox + "?nif=89890001K&fecha=01-09-2024&importe=1.00")
+		assert.ErrorContains(t, err, "missing numserie")
+		_, err = ParseQRURL(QRURLSandbox + "?nif=89890001K&numserie=1&fecha=2024-09-01&importe=1.00")
+		assert.ErrorContains(t, err, "fecha")
+		_, err = ParseQRURL(QRURLSandbox + "?nif=89890001K&numserie=1&fecha=01-09-2024&importe=abc")
+		assert.ErrorContains(t, err, "importe")
+	})
+}
@@ -0,0 +1,95 @@
+package verifactu
+
+import (
+	"context"
+	"fmt"
+)
+
+// RecordFinder is implemented by stores of submitted records so that the
+// details scanned from a QR code can be checked locally.
+type RecordFinder interface {
+	// FindRecord provides the latest result stored for the invoice issued
+	// by the NIF with the given series and code, and issue date in the
+	// dd-mm-yyyy format. A nil result and error should be returned when
+	// the invoice is not known.
+	FindRecord(ctx context.Context, nif, numSerie, fecha string) (*InvoiceResult, error)
+}
+
+// RecordFinderFunc allows a function to be used as a RecordFinder.
+type RecordFinderFunc func(ctx context.Context, nif, numSerie, fecha string) (*InvoiceResult, error)
+
+// FindRecord calls the function.
+func (f RecordFinderFunc) FindRecord(ctx context.Context, nif, numSerie, fecha string) (*InvoiceResult, error) {
+	return f(ctx, nif, numSerie, fecha)
+}
+
+// QRCheck describes the outcome of checking a scanned QR code against the
+// stored records.
+type QRCheck struct {
+	// Data contains the details parsed from the QR code URL.
+	Data *QRData
+	// Result is the stored record for the invoice, if found.
+	Result *InvoiceResult
+
+	// Found is true when a registration exists for the invoice.
+	Found bool
+	// Accepted is true when the AEAT registered the invoice, even if with
+	// errors.
+	Accepted bool
+	// Cancelled is true when the latest record is an accepted cancellation,
+	// or the AEAT reports the invoice as cancelled.
+	Cancelled bool
+	// AmountMatches is true when the amount in the QR code is the same as
+	// the registration's ImporteTotal.
+	AmountMatches bool
+	// EnvironmentMatches is true when the QR code points to the environment
+	// the record was generated in. Records with an unknown environment never
+	// match.
+	EnvironmentMatches bool
+}
+
+// Valid returns true if the invoice exists in the same environment, was
+// accepted and not cancelled, and the amount printed in the QR code is
+// correct.
+func (c *QRCheck) Valid() bool {
+	return c.Found && c.Accepted && !c.Cancelled && c.AmountMatches && c.EnvironmentMatches
+}
+
+// CheckQRURL parses the URL scanned from an invoice's QR code and checks it
+// against the record provided by the finder. Errors are only returned when
+// the URL is not valid or the record could not be loaded; the outcome of the
+// check is described by the result.
+func CheckQRURL(ctx context.Context, raw string, f RecordFinder) (*QRCheck, error) {
+	d, err := ParseQRURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	c := &QRCheck{Data: d}
+	res, err := f.FindRecord(ctx, d.NIF, d.NumSerie, d.Fecha)
+	if err != nil {
+		return nil, fmt.Errorf("finding record: %w", err)
+	}
+	if res == nil || res.Request == nil {
+		return c, nil
+	}
+	c.Result = res
+	c.EnvironmentMatches = res.Request.Environment() == d.Environment
+	cancelled := !res.Missing() && res.Response.Status == StatusCancelled
+	accepted := !res.Missing() && (res.Response.Status == StatusCorrect ||
+		res.Response.Status == StatusAcceptedWithErrors || cancelled)
+
+	switch {
+	case res.Request.Registration != nil:
+		c.Found = true
+		c.Accepted = accepted
+		c.Cancelled = cancelled
+		c.AmountMatches = d.Matches(res.Request.Registration)
+	case res.Request.Cancellation != nil:
+		// The registration itself is no longer available, but a cancellation
+		// is only accepted for invoices registered previously.
+		c.Found = true
+		c.Accepted = accepted
+		c.Cancelled = accepted
+	}
+	return c, nil
+}
@@ -0,0 +1,140 @@
+package verifactu_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/invopop/gobl"
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	vf "github.com/invopop/gobl/addons/es/verifactu"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRecords keeps the latest result for each invoice.
+type memoryRecords map[string]*verifactu.InvoiceResult
+
+func (m memoryRecords) FindRecord(_ context.Context, nif, numSerie, fecha string) (*verifactu.InvoiceResult, error) {
+	return m[nif+"/"+numSerie+"/"+fecha], nil
+}
+
+func (m memoryRecords) add(results []*verifactu.InvoiceResult) {
+	for _, r := range results {
+		cd := r.ChainData()
+		m[cd.IDIssuer+"/"+cd.NumSeries+"/"+cd.IssueDate] = r
+	}
+}
+
+func TestCheckQRURL(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	srv := verifactutest.NewServer()
+	t.Cleanup(srv.Close)
+	c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL))
+	require.NoError(t, err)
+	ctx := context.Background()
+	records := make(memoryRecords)
+
+	send := func(t *testing.T, env *gobl.Envelope, fn func(ir *verifactu.InvoiceRequest)) {
+		t.Helper()
+		inv := env.Extract().(*bill.Invoice)
+		ir, err := c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		fn(ir)
+		res, err := c.SendInvoiceRequest(ctx, ir)
+		require.NoError(t, err)
+		records.add(res.Results(ir))
+	}
+
+	env := test.LoadEnvelope("inv-base.json")
+	reg, err := c.RegisterInvoice(env, nil)
+	require.NoError(t, err)
+	url := env.Head.GetStamp(vf.StampQR).Value
+
+	t.Run("not found", func(t *testing.T) {
+		chk, err := verifactu.CheckQRURL(ctx, url, records)
+		require.NoError(t, err)
+		assert.False(t, chk.Found)
+		assert.False(t, chk.Valid())
+		assert.Equal(t, "SAMPLE-004", chk.Data.NumSerie)
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		send(t, env, func(ir *verifactu.InvoiceRequest) { ir.AddRegistration(reg) })
+		chk, err := verifactu.CheckQRURL(ctx, url, records)
+		require.NoError(t, err)
+		assert.True(t, chk.Found)
+		assert.True(t, chk.Accepted)
+		assert.True(t, chk.AmountMatches)
+		assert.True(t, chk.EnvironmentMatches)
+		assert.True(t, chk.Valid())
+		assert.Same(t, reg, chk.Result.Request.Registration)
+	})
+
+	t.Run("environment mismatch", func(t *testing.T) {
+		d, err := verifactu.ParseQRURL(url)
+		require.NoError(t, err)
+		d.Environment = verifactu.EnvironmentProduction
+		chk, err := verifactu.CheckQRURL(ctx, d.URL(), records)
+		require.NoError(t, err)
+		assert.True(t, chk.Found)
+		assert.True(t, chk.AmountMatches)
+		assert.False(t, chk.EnvironmentMatches)
+		assert.False(t, chk.Valid())
+	})
+
+	t.Run("registration reported as cancelled", func(t *testing.T) {
+		res := *records["B85905495/SAMPLE-004/13-11-2024"]
+		line := *res.Response
+		line.Status = verifactu.StatusCancelled
+		res.Response = &line
+		f := verifactu.RecordFinderFunc(func(context.Context, string, string, string) (*verifactu.InvoiceResult, error) {
+			return &res, nil
+		})
+		chk, err := verifactu.CheckQRURL(ctx, url, f)
+		require.NoError(t, err)
+		assert.True(t, chk.Accepted)
+		assert.True(t, chk.Cancelled)
+		assert.False(t, chk.Valid())
+	})
+
+	t.Run("amount mismatch", func(t *testing.T) {
+		d, err := verifactu.ParseQRURL(url)
+		require.NoError(t, err)
+		d.Importe = d.Importe.Add(d.Importe)
+		chk, err := verifactu.CheckQRURL(ctx, d.URL(), records)
+		require.NoError(t, err)
+		assert.True(t, chk.Found)
+		assert.False(t, chk.AmountMatches)
+		assert.False(t, chk.Valid())
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		can, err := c.CancelInvoice(test.LoadEnvelope("inv-base.json"), reg.ChainData())
+		require.NoError(t, err)
+		send(t, env, func(ir *verifactu.InvoiceRequest) { ir.AddCancellation(can) })
+		chk, err := verifactu.CheckQRURL(ctx, url, records)
+		require.NoError(t, err)
+		assert.True(t, chk.Found)
+		assert.True(t, chk.Cancelled)
+		assert.False(t, chk.Valid())
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		_, err := verifactu.CheckQRURL(ctx, "https://example.com/", records)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+	})
+
+	t.Run("finder error", func(t *testing.T) {
+		f := verifactu.RecordFinderFunc(func(context.Context, string, string, string) (*verifactu.InvoiceResult, error) {
+			return nil, errors.New("offline")
+		})
+		_, err := verifactu.CheckQRURL(ctx, url, f)
+		assert.ErrorContains(t, err, "offline")
+	})
+}
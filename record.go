@@ -0,0 +1,227 @@
+package verifactu
+
+import (
+	"strings"
+
+	"github.com/invopop/xmldsig"
+)
+
+// RecordKind identifies the type of record.
+type RecordKind string
+
+// Supported record kinds
+const (
+	RecordKindRegistration RecordKind = "registration"
+	RecordKindCancellation RecordKind = "cancellation"
+	RecordKindEvent        RecordKind = "event"
+)
+
+// RecordIdentity identifies a record within its chain. For invoice records
+// the code and date are the invoice's series and code, and issue date. For
+// events they are the event type and generation timestamp.
+type RecordIdentity struct {
+	Issuer string `json:"issuer"`
+	Code   string `json:"code"`
+	Date   string `json:"date"`
+}
+
+// String provides a key for the identity, useful for storage.
+func (id RecordIdentity) String() string {
+	return strings.Join([]string{id.Issuer, id.Code, id.Date}, "/")
+}
+
+// Record describes the details shared by registrations, cancellations and
+// events, so that they can be stored, verified and exported in the same way.
+// Invoice and event records belong to separate chains, so the Kind should be
+// used to keep them apart.
+type Record interface {
+	// Kind provides the type of record.
+	Kind() RecordKind
+	// Identity provides the identity of the record.
+	Identity() RecordIdentity
+	// Fingerprint provides the record's own fingerprint.
+	Fingerprint() string
+	// PreviousFingerprint provides the fingerprint of the previous record in
+	// the chain, or an empty string for the first record.
+	PreviousFingerprint() string
+	// GenerationTimestamp provides the time the record was generated, in
+	// the format included in the record.
+	GenerationTimestamp() string
+	// Environment provides the environment the record was generated in,
+	// if known.
+	Environment() Environment
+	// GetSignature provides the record's XML signature, if signed.
+	GetSignature() *xmldsig.Signature
+	// Bytes prepares an XML document suitable for persistence.
+	Bytes() ([]byte, error)
+}
+
+var (
+	_ Record = (*InvoiceRegistration)(nil)
+	_ Record = (*InvoiceCancellation)(nil)
+	_ Record = (*EventRegistration)(nil)
+)
+
+// Kind returns RecordKindRegistration.
+func (r *InvoiceRegistration) Kind() RecordKind {
+	return RecordKindRegistration
+}
+
+// Identity provides the invoice's identity.
+func (r *InvoiceRegistration) Identity() RecordIdentity {
+	if r.IDFactura == nil {
+		return RecordIdentity{}
+	}
+	return RecordIdentity{
+		Issuer: r.IDFactura.IDEmisorFactura,
+		Code:   r.IDFactura.NumSerieFactura,
+		Date:   r.IDFactura.FechaExpedicionFactura,
+	}
+}
+
+// Fingerprint provides the Huella.
+func (r *InvoiceRegistration) Fingerprint() string {
+	return r.Huella
+}
+
+// PreviousFingerprint provides the Huella of the previous record.
+func (r *InvoiceRegistration) PreviousFingerprint() string {
+	return r.Encadenamiento.previous()
+}
+
+// GenerationTimestamp provides the FechaHoraHusoGenRegistro.
+func (r *InvoiceRegistration) GenerationTimestamp() string {
+	return r.FechaHoraHusoGenRegistro
+}
+
+// GetSignature provides the signature, if any.
+func (r *InvoiceRegistration) GetSignature() *xmldsig.Signature {
+	return r.Signature
+}
+
+// Kind returns RecordKindCancellation.
+func (c *InvoiceCancellation) Kind() RecordKind {
+	return RecordKindCancellation
+}
+
+// Identity provides the cancelled invoice's identity.
+func (c *InvoiceCancellation) Identity() RecordIdentity {
+	if c.IDFactura == nil {
+		return RecordIdentity{}
+	}
+	return RecordIdentity{
+		Issuer: c.IDFactura.IDEmisorFactura,
+		Code:   c.IDFactura.NumSerieFactura,
+		Date:   c.IDFactura.FechaExpedicionFactura,
+	}
+}
+
+// Fingerprint provides the Huella.
+func (c *InvoiceCancellation) Fingerprint() string {
+	return c.Huella
+}
+
+// PreviousFingerprint provides the Huella of the previous record.
+func (c *InvoiceCancellation) PreviousFingerprint() string {
+	return c.Encadenamiento.previous()
+}
+
+// GenerationTimestamp provides the FechaHoraHusoGenRegistro.
+func (c *InvoiceCancellation) GenerationTimestamp() string {
+	return c.FechaHoraHusoGenRegistro
+}
+
+// GetSignature provides the signature, if any.
+func (c *InvoiceCancellation) GetSignature() *xmldsig.Signature {
+	return c.Signature
+}
+
+// Kind returns RecordKindEvent.
+func (r *EventRegistration) Kind() RecordKind {
+	return RecordKindEvent
+}
+
+// Identity provides the event's issuer, type and generation timestamp.
+func (r *EventRegistration) Identity() RecordIdentity {
+	if r.Event == nil {
+		return RecordIdentity{}
+	}
+	id := RecordIdentity{
+		Code: r.Event.EventType,
+		Date: r.Event.GenerationTimestamp,
+	}
+	if r.Event.Issuer != nil {
+		id.Issuer = r.Event.Issuer.NIF
+	}
+	return id
+}
+
+// Fingerprint provides the HuellaEvento.
+func (r *EventRegistration) Fingerprint() string {
+	if r.Event == nil {
+		return ""
+	}
+	return r.Event.Fingerprint
+}
+
+// PreviousFingerprint provides the HuellaEvento of the previous event.
+func (r *EventRegistration) PreviousFingerprint() string {
+	if r.Event == nil || r.Event.Chaining == nil || r.Event.Chaining.PreviousEvent == nil {
+		return ""
+	}
+	return r.Event.Chaining.PreviousEvent.Fingerprint
+}
+
+// GenerationTimestamp provides the FechaHoraHusoGenEvento.
+func (r *EventRegistration) GenerationTimestamp() string {
+	if r.Event == nil {
+		return ""
+	}
+	return r.Event.GenerationTimestamp
+}
+
+// GetSignature provides the signature, if any.
+func (r *EventRegistration) GetSignature() *xmldsig.Signature {
+	if r.Event == nil {
+		return nil
+	}
+	return r.Event.Signature
+}
+
+// Record provides the registration or cancellation in the line.
+func (line *InvoiceRequestLine) Record() Record {
+	switch {
+	case line.Registration != nil:
+		return line.Registration
+	case line.Cancellation != nil:
+		return line.Cancellation
+	}
+	return nil
+}
+
+// AddRecord adds the registration or cancellation to the request body.
+// Events cannot be included in invoice requests.
+func (req *InvoiceRequest) AddRecord(r Record) error {
+	switch d := r.(type) {
+	case *InvoiceRegistration:
+		if d == nil {
+			return ErrValidation.WithMessage("missing registration")
+		}
+		req.AddRegistration(d)
+	case *InvoiceCancellation:
+		if d == nil {
+			return ErrValidation.WithMessage("missing cancellation")
+		}
+		req.AddCancellation(d)
+	default:
+		return ErrValidation.WithMessage("only invoice records can be added to requests")
+	}
+	return nil
+}
+
+func (e *Encadenamiento) previous() string {
+	if e == nil || e.RegistroAnterior == nil {
+		return ""
+	}
+	return e.RegistroAnterior.Huella
+}
@@ -0,0 +1,71 @@
+package verifactu_test
+
+import (
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+	require.NoError(t, err)
+
+	reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+	require.NoError(t, err)
+	can, err := c.CancelInvoice(test.LoadEnvelope("inv-base.json"), reg.ChainData())
+	require.NoError(t, err)
+	evt1, err := c.RegisterEvent(test.LoadEnvelope("status-system-startup.json"), nil)
+	require.NoError(t, err)
+	evt2, err := c.RegisterEvent(test.LoadEnvelope("status-system-shutdown.json"), evt1.ChainData())
+	require.NoError(t, err)
+
+	t.Run("invoice records", func(t *testing.T) {
+		records := []verifactu.Record{reg, can}
+		assert.Equal(t, verifactu.RecordKindRegistration, records[0].Kind())
+		assert.Equal(t, verifactu.RecordKindCancellation, records[1].Kind())
+		for _, r := range records {
+			assert.Equal(t, "B85905495/SAMPLE-004/13-11-2024", r.Identity().String())
+			assert.Equal(t, "2024-11-26T05:00:00+01:00", r.GenerationTimestamp())
+			assert.Equal(t, verifactu.EnvironmentSandbox, r.Environment())
+			assert.Nil(t, r.GetSignature())
+			data, err := r.Bytes()
+			require.NoError(t, err)
+			assert.Contains(t, string(data), r.Fingerprint())
+		}
+		assert.Equal(t, reg.Huella, records[0].Fingerprint())
+		assert.Empty(t, records[0].PreviousFingerprint())
+		assert.Equal(t, reg.Huella, records[1].PreviousFingerprint())
+	})
+
+	t.Run("event records", func(t *testing.T) {
+		var r verifactu.Record = evt2
+		assert.Equal(t, verifactu.RecordKindEvent, r.Kind())
+		assert.Equal(t, evt2.Event.EventType, r.Identity().Code)
+		assert.Equal(t, evt2.Event.GenerationTimestamp, r.Identity().Date)
+		assert.Equal(t, evt2.Event.Fingerprint, r.Fingerprint())
+		assert.Equal(t, evt1.Event.Fingerprint, r.PreviousFingerprint())
+		assert.Empty(t, verifactu.Record(evt1).PreviousFingerprint())
+		assert.Equal(t, "2024-11-26T05:00:00+01:00", r.GenerationTimestamp())
+	})
+
+	t.Run("requests", func(t *testing.T) {
+		_, inv := test.LoadInvoice("inv-base.json")
+		ir, err := c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		require.NoError(t, ir.AddRecord(reg))
+		require.NoError(t, ir.AddRecord(can))
+		assert.ErrorIs(t, ir.AddRecord(evt1), verifactu.ErrValidation)
+		assert.ErrorIs(t, ir.AddRecord(nil), verifactu.ErrValidation)
+		assert.ErrorIs(t, ir.AddRecord((*verifactu.InvoiceRegistration)(nil)), verifactu.ErrValidation)
+		assert.ErrorIs(t, ir.AddRecord((*verifactu.InvoiceCancellation)(nil)), verifactu.ErrValidation)
+		require.Len(t, ir.Lines, 2)
+		assert.Same(t, reg, ir.Lines[0].Record())
+		assert.Same(t, can, ir.Lines[1].Record())
+	})
+}
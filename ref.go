@@ -0,0 +1,63 @@
+package verifactu
+
+import (
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+)
+
+// RefFunc provides the external reference, or "RefExterna", to include in the
+// records generated for the envelope. The AEAT returns the reference in its
+// responses, so it can be used to correlate records with other systems. An
+// empty string means no reference will be included.
+type RefFunc func(env *gobl.Envelope) string
+
+// RefFromUUID uses the UUID of the invoice as the external reference.
+func RefFromUUID() RefFunc {
+	return func(env *gobl.Envelope) string {
+		inv, ok := env.Extract().(*bill.Invoice)
+		if !ok || inv.UUID.IsZero() {
+			return ""
+		}
+		return inv.UUID.String()
+	}
+}
+
+// RefFromMeta uses the value of the provided key in the invoice's meta data
+// as the external reference.
+func RefFromMeta(key cbc.Key) RefFunc {
+	return func(env *gobl.Envelope) string {
+		inv, ok := env.Extract().(*bill.Invoice)
+		if !ok {
+			return ""
+		}
+		return inv.Meta[key]
+	}
+}
+
+// WithRefExterna defines how to determine the external reference included in
+// the registrations and cancellations generated by the client.
+func WithRefExterna(fn RefFunc) Option {
+	return func(c *Client) {
+		c.refFunc = fn
+	}
+}
+
+// WithRef sets the external reference of the generated record, overriding
+// the client's RefFunc.
+func WithRef(ref string) GenerateOption {
+	return func(o *generateOptions) {
+		o.ref = ref
+	}
+}
+
+// refFor determines the external reference to use for the envelope.
+func (c *Client) refFor(env *gobl.Envelope, o *generateOptions) string {
+	if o.ref != "" {
+		return o.ref
+	}
+	if c.refFunc != nil {
+		return c.refFunc(env)
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+package verifactu_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/cbc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefExterna(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+
+	t.Run("not set by default", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil)
+		require.NoError(t, err)
+		assert.Empty(t, reg.RefExterna)
+	})
+
+	t.Run("from uuid", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithRefExterna(verifactu.RefFromUUID()),
+		)
+		require.NoError(t, err)
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "3aea7b56-59d8-4beb-90bd-f8f280d852a0", reg.RefExterna)
+
+		can, err := c.CancelInvoice(env, reg.ChainData())
+		require.NoError(t, err)
+		assert.Equal(t, "3aea7b56-59d8-4beb-90bd-f8f280d852a0", can.RefExterna)
+	})
+
+	t.Run("from meta", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithRefExterna(verifactu.RefFromMeta("erp-id")),
+		)
+		require.NoError(t, err)
+		env, inv := test.LoadInvoice("inv-base.json")
+		inv.Meta = cbc.Meta{"erp-id": "ERP-0001"}
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "ERP-0001", reg.RefExterna)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware,
+			verifactu.WithCurrentTime(ts),
+			verifactu.WithRefExterna(verifactu.RefFromUUID()),
+		)
+		require.NoError(t, err)
+		reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil, verifactu.WithRef("CUSTOM-1"))
+		require.NoError(t, err)
+		assert.Equal(t, "CUSTOM-1", reg.RefExterna)
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+		require.NoError(t, err)
+		_, err = c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), nil, verifactu.WithRef(strings.Repeat("X", 61)))
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+		assert.ErrorContains(t, err, "RefExterna")
+	})
+
+	t.Run("matches responses", func(t *testing.T) {
+		srv := verifactutest.NewServer()
+		t.Cleanup(srv.Close)
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL))
+		require.NoError(t, err)
+
+		env, inv := test.LoadInvoice("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil, verifactu.WithRef("REF-1"))
+		require.NoError(t, err)
+		ir, err := c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg)
+
+		res, err := c.SendInvoiceRequest(context.Background(), ir)
+		require.NoError(t, err)
+		results := res.Results(ir)
+		require.Len(t, results, 1)
+		require.NotNil(t, results[0].Response)
+		assert.Equal(t, "REF-1", results[0].Response.Ref)
+		assert.Equal(t, "REF-1", results[0].Ref())
+	})
+}
@@ -0,0 +1,424 @@
+package verifactu
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/rules"
+	"github.com/invopop/gobl/rules/is"
+)
+
+// RegistrationRules applies the business validations published by the AEAT to
+// invoice registrations, so that records likely to be rejected or accepted with
+// errors can be detected before sending. Assertion IDs end with the AEAT error
+// code the record would receive. Checks without a published AEAT code use
+// local IDs starting with "L", for which FaultErrorCode returns nil.
+//
+// Rules come from:
+// https://www.agenciatributaria.es/static_files/AEAT_Desarrolladores/EEDD/IVA/VERI-FACTU/Validaciones_Errores_Veri-Factu.pdf
+var RegistrationRules = rules.NewSet("VERIFACTU", registrationRuleSet())
+
+// ValidateRegistration checks the registration against the AEAT business rules
+// and returns any faults found, or nil.
+func ValidateRegistration(reg *InvoiceRegistration) rules.Faults {
+	return RegistrationRules.Validate(reg)
+}
+
+// FaultErrorCode provides the catalogue entry of the AEAT error code predicted
+// by a fault raised by the registration rules.
+func FaultErrorCode(f *rules.Fault) *ErrorCode {
+	code := string(f.Code())
+	if i := strings.LastIndex(code, "-"); i >= 0 {
+		code = code[i+1:]
+	}
+	return LookupErrorCode(code)
+}
+
+var (
+	// amountTolerance is the maximum difference accepted by the AEAT between
+	// the totals and the sum of the breakdown.
+	amountTolerance = num.MakeAmount(1000, 2)
+	// simplifiedLimit is the maximum amount of a simplified invoice.
+	simplifiedLimit = num.MakeAmount(3000, 0)
+	// breakdownZero is used to add up breakdown amounts without losing
+	// precision.
+	breakdownZero = num.MakeAmount(0, 2)
+)
+
+// recipientDocTypes require the Destinatarios block.
+var recipientDocTypes = []string{"F1", "F3", "R1", "R2", "R3", "R4"}
+
+// noRecipientDocTypes must not include the Destinatarios block.
+var noRecipientDocTypes = []string{"F2", "R5"}
+
+// validVATRates contains the VAT rates accepted for taxed operations.
+var validVATRates = []string{"0", "2", "4", "5", "7.5", "10", "21"}
+
+// validIGICRates contains the IGIC rates of the Canary Islands, including the
+// increased and special rates.
+var validIGICRates = []string{"0", "1", "3", "5", "7", "9.5", "13.5", "15", "20"}
+
+// validIPSIRates contains the IPSI rates applied in Ceuta and Melilla.
+var validIPSIRates = []string{"0", "0.5", "1", "2", "3", "4", "5", "6", "7", "8", "10"}
+
+// validTaxRates maps the L1 tax codes to the rates accepted for them. Other
+// taxes are not checked.
+var validTaxRates = map[string][]string{
+	taxCodeVAT:  validVATRates,
+	taxCodeIGIC: validIGICRates,
+	taxCodeIPSI: validIPSIRates,
+}
+
+// compensationRates contains the flat-rate compensation percentages used
+// instead of VAT rates in the special regime for agriculture, livestock and
+// fishing (REAGYP).
+var compensationRates = []string{"10.5", "12"}
+
+// zeroVATFrom and zeroVATTo limit the period in which the temporary 0% VAT
+// rate on basic foods could be applied to taxed operations. Outside of it, a
+// rate of 0 is only expected in reverse charge operations.
+var (
+	zeroVATFrom = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	zeroVATTo   = time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// VAT regimes with specific rate rules.
+const (
+	regimeGeneral   = "01"
+	regimeSurcharge = "18"
+	regimeREAGYP    = "19"
+)
+
+// validSurchargeRates maps the VAT rates to the equivalence surcharge rates
+// that may accompany them.
+var validSurchargeRates = map[string][]string{
+	"21": {"5.2", "1.75"},
+	"10": {"1.4"},
+	"5":  {"0.5", "0.62"},
+	"4":  {"0.5"},
+}
+
+// foreignTaxRegimes cover VAT operations taxed in other member states (OSS),
+// where the foreign tax is included in the totals but not in the breakdown.
+// The same code is used for retail traders in IGIC.
+var foreignTaxRegimes = []string{"17"}
+
+// futureOperationRegimes allow operation dates later than the date the record
+// was generated.
+var futureOperationRegimes = []string{"14", "15"}
+
+func registrationRuleSet() *rules.Set {
+	return rules.For(new(InvoiceRegistration),
+		rules.When(isDocType(recipientDocTypes...),
+			rules.Field("Destinatarios",
+				rules.Assert("1189", "recipients are required for this invoice type", is.Present),
+			),
+		),
+		rules.When(isDocType(noRecipientDocTypes...),
+			rules.Field("Destinatarios",
+				rules.Assert("1190", "recipients are not allowed for this invoice type", is.Empty),
+			),
+		),
+		rules.When(isDocType("F2"),
+			rules.Assert("1150", "simplified invoice amount must not exceed 3000", withinSimplifiedLimit),
+		),
+		rules.When(isDocType(correctiveDocTypes()...),
+			rules.Field("TipoRectificativa",
+				rules.Assert("1114", "corrective invoices require a correction type", is.Present),
+			),
+		),
+		rules.When(isNotDocType(correctiveDocTypes()...),
+			rules.Field("TipoRectificativa",
+				rules.Assert("1115", "correction type only allowed in corrective invoices", is.Empty),
+			),
+			rules.Field("FacturasRectificadas",
+				rules.Assert("1117", "corrected invoices only allowed in corrective invoices", is.Nil),
+			),
+		),
+		rules.When(isNotDocType("F3"),
+			rules.Field("FacturasSustituidas",
+				rules.Assert("1116", "substituted invoices only allowed in F3 invoices", is.Nil),
+			),
+		),
+		rules.When(isSubstitution,
+			rules.Field("ImporteRectificacion",
+				rules.Assert("1118", "substitution amounts are required", is.Present),
+			),
+		),
+		rules.When(is.Func("not substitution", func(v any) bool { return !isSubstitution.Check(v) }),
+			rules.Field("ImporteRectificacion",
+				rules.Assert("1119", "substitution amounts only allowed in substitutions", is.Nil),
+			),
+		),
+		rules.When(isNotDocType(couponDocTypes...),
+			rules.Field("Cupon",
+				rules.Assert("1157", "coupons only allowed in R1 or R5 invoices", is.In("", "N")),
+			),
+		),
+		// local check, the AEAT does not publish a code for it
+		rules.Assert("L02", "agreement system requires the agreement registration number", validAgreement),
+		rules.Assert("1112", "issue date must not be later than the generation date", validIssueDate),
+		rules.Assert("1125", "operation date must not be later than the generation date", validOperationDate),
+		rules.Assert("1124", "zero VAT rate only allowed for exempt, not subject or reverse charge operations", validZeroVATRate),
+		rules.Assert("2005", "total amount must match the breakdown", matchesImporteTotal),
+		rules.Assert("2006", "total tax must match the breakdown", matchesCuotaTotal),
+		rules.Field("Desglose",
+			rules.Field("DetalleDesglose",
+				rules.Each(
+					rules.When(isTaxed,
+						rules.Assert("1124", "tax rate not valid for the tax and regime", is.Func("valid tax rate", validTaxRate)),
+					),
+					rules.When(isNotVAT,
+						rules.Field("TipoRecargoEquivalencia",
+							rules.Assert("1127", "surcharges only allowed with VAT", is.Empty),
+						),
+					),
+					rules.When(isVATOutsideRegime(regimeGeneral, regimeSurcharge),
+						rules.Field("TipoRecargoEquivalencia",
+							// local check, the AEAT does not publish a code for it
+							rules.Assert("L01", "surcharges only allowed in the general or equivalence surcharge regimes", is.Empty),
+						),
+					),
+					rules.When(isTaxedVAT,
+						rules.Assert("1160", "surcharge not valid for the 5% rate", validSurchargeFor("5")),
+						rules.Assert("1162", "surcharge not valid for the 21% rate", validSurchargeFor("21")),
+						rules.Assert("1163", "surcharge not valid for the 10% rate", validSurchargeFor("10")),
+						rules.Assert("1164", "surcharge not valid for the 4% rate", validSurchargeFor("4")),
+					),
+				),
+			),
+		),
+	)
+}
+
+func correctiveDocTypes() []string {
+	out := make([]string, len(correctiveCodes))
+	for i, c := range correctiveCodes {
+		out[i] = c.String()
+	}
+	return out
+}
+
+func isDocType(types ...string) is.FuncTest {
+	return is.Func("invoice type "+strings.Join(types, ", "), func(v any) bool {
+		reg, _ := v.(*InvoiceRegistration)
+		return reg != nil && slices.Contains(types, reg.TipoFactura)
+	})
+}
+
+func isNotDocType(types ...string) is.FuncTest {
+	return is.Func("invoice type not "+strings.Join(types, ", "), func(v any) bool {
+		reg, _ := v.(*InvoiceRegistration)
+		return reg != nil && !slices.Contains(types, reg.TipoFactura)
+	})
+}
+
+var isSubstitution = is.Func("substitution", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	return reg != nil && reg.TipoRectificativa == "S"
+})
+
+var isTaxed = is.Func("taxed", func(v any) bool {
+	dd, _ := v.(*DetalleDesglose)
+	return dd != nil && dd.CalificacionOperacion == "S1"
+})
+
+var isTaxedVAT = is.Func("taxed VAT", func(v any) bool {
+	dd, _ := v.(*DetalleDesglose)
+	return dd != nil && isVAT(dd) && dd.CalificacionOperacion == "S1"
+})
+
+var isNotVAT = is.Func("not VAT", func(v any) bool {
+	dd, _ := v.(*DetalleDesglose)
+	return dd != nil && !isVAT(dd)
+})
+
+func isVATOutsideRegime(regimes ...string) is.FuncTest {
+	return is.Func("VAT outside regime "+strings.Join(regimes, ", "), func(v any) bool {
+		dd, _ := v.(*DetalleDesglose)
+		return dd != nil && isVAT(dd) && !slices.Contains(regimes, dd.ClaveRegimen)
+	})
+}
+
+// isVAT reports whether the breakdown line is for VAT, the default tax.
+func isVAT(dd *DetalleDesglose) bool {
+	return dd.Impuesto == "" || dd.Impuesto == taxCodeVAT
+}
+
+var withinSimplifiedLimit = is.Func("simplified limit", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	if reg == nil || reg.NumRegistroAcuerdoFacturacion != "" || reg.FacturaSinIdentifDestinatarioArt61d == "S" {
+		return true
+	}
+	base, tax, _ := breakdownTotals(reg)
+	return base.Add(tax).Abs().Compare(simplifiedLimit) <= 0
+})
+
+var validAgreement = is.Func("valid agreement", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	return reg == nil || reg.IdAcuerdoSistemaInformatico == "" || reg.NumRegistroAcuerdoFacturacion != ""
+})
+
+var validIssueDate = is.Func("valid issue date", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	if reg == nil || reg.IDFactura == nil {
+		return true
+	}
+	return !dateAfterGeneration(reg, reg.IDFactura.FechaExpedicionFactura)
+})
+
+var validOperationDate = is.Func("valid operation date", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	if reg == nil || reg.FechaOperacion == "" || hasRegime(reg, futureOperationRegimes...) {
+		return true
+	}
+	return !dateAfterGeneration(reg, reg.FechaOperacion)
+})
+
+var matchesImporteTotal = is.Func("matches ImporteTotal", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	if reg == nil || reg.Desglose == nil || hasRegime(reg, partialBreakdownRegimes...) || hasVATRegime(reg, foreignTaxRegimes...) {
+		return true
+	}
+	base, tax, surcharge := breakdownTotals(reg)
+	return withinTolerance(reg.ImporteTotal, base.Add(tax).Add(surcharge))
+})
+
+var matchesCuotaTotal = is.Func("matches CuotaTotal", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	if reg == nil || reg.Desglose == nil || hasVATRegime(reg, foreignTaxRegimes...) {
+		return true
+	}
+	_, tax, surcharge := breakdownTotals(reg)
+	return withinTolerance(reg.CuotaTotal, tax.Add(surcharge))
+})
+
+func validTaxRate(v any) bool {
+	dd, _ := v.(*DetalleDesglose)
+	if dd == nil || dd.TipoImpositivo == "" {
+		return true // schema will complain
+	}
+	if isVAT(dd) && dd.ClaveRegimen == regimeREAGYP {
+		return containsRate(compensationRates, dd.TipoImpositivo)
+	}
+	code := dd.Impuesto
+	if code == "" {
+		code = taxCodeVAT
+	}
+	list, ok := validTaxRates[code]
+	if !ok {
+		return true
+	}
+	return containsRate(list, dd.TipoImpositivo)
+}
+
+var validZeroVATRate = is.Func("valid zero VAT rate", func(v any) bool {
+	reg, _ := v.(*InvoiceRegistration)
+	if reg == nil || reg.Desglose == nil || reg.IDFactura == nil {
+		return true
+	}
+	date := reg.FechaOperacion
+	if date == "" {
+		date = reg.IDFactura.FechaExpedicionFactura
+	}
+	d, err := time.Parse("02-01-2006", date)
+	if err == nil && !d.Before(zeroVATFrom) && !d.After(zeroVATTo) {
+		return true
+	}
+	for _, dd := range reg.Desglose.DetalleDesglose {
+		if isTaxedVAT.Check(dd) && dd.ClaveRegimen != regimeREAGYP && sameRate(dd.TipoImpositivo, "0") && dd.TipoImpositivo != "" {
+			return false
+		}
+	}
+	return true
+})
+
+func validSurchargeFor(rate string) is.FuncTest {
+	return is.Func("valid surcharge for "+rate, func(v any) bool {
+		dd, _ := v.(*DetalleDesglose)
+		if dd == nil || dd.TipoRecargoEquivalencia == "" || !sameRate(dd.TipoImpositivo, rate) {
+			return true
+		}
+		return containsRate(validSurchargeRates[rate], dd.TipoRecargoEquivalencia)
+	})
+}
+
+// breakdownTotals sums the bases, taxes and surcharges of the breakdown.
+func breakdownTotals(reg *InvoiceRegistration) (base, tax, surcharge num.Amount) {
+	base, tax, surcharge = breakdownZero, breakdownZero, breakdownZero
+	if reg.Desglose == nil {
+		return
+	}
+	for _, dd := range reg.Desglose.DetalleDesglose {
+		base = base.Add(parseAmount(dd.BaseImponibleOImporteNoSujeto))
+		tax = tax.Add(parseAmount(dd.CuotaRepercutida))
+		surcharge = surcharge.Add(parseAmount(dd.CuotaRecargoEquivalencia))
+	}
+	return
+}
+
+func hasRegime(reg *InvoiceRegistration, regimes ...string) bool {
+	if reg.Desglose == nil {
+		return false
+	}
+	for _, dd := range reg.Desglose.DetalleDesglose {
+		if slices.Contains(regimes, dd.ClaveRegimen) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVATRegime is like hasRegime, but only considers VAT lines, as IGIC uses
+// some of the same codes for other regimes.
+func hasVATRegime(reg *InvoiceRegistration, regimes ...string) bool {
+	if reg.Desglose == nil {
+		return false
+	}
+	for _, dd := range reg.Desglose.DetalleDesglose {
+		if isVAT(dd) && slices.Contains(regimes, dd.ClaveRegimen) {
+			return true
+		}
+	}
+	return false
+}
+
+// dateAfterGeneration reports whether the DD-MM-YYYY date is later than the
+// day the record was generated.
+func dateAfterGeneration(reg *InvoiceRegistration, date string) bool {
+	d, err := time.Parse("02-01-2006", date)
+	if err != nil {
+		return false // schema will complain
+	}
+	gen, err := time.Parse(time.RFC3339, reg.FechaHoraHusoGenRegistro)
+	if err != nil {
+		return false
+	}
+	y, m, day := gen.Date()
+	return d.After(time.Date(y, m, day, 0, 0, 0, 0, time.UTC))
+}
+
+func withinTolerance(total, sum num.Amount) bool {
+	return total.MatchPrecision(sum).Subtract(sum).Abs().Compare(amountTolerance) <= 0
+}
+
+func parseAmount(s string) num.Amount {
+	if s == "" {
+		return num.AmountZero
+	}
+	a, err := num.AmountFromString(s)
+	if err != nil {
+		return num.AmountZero
+	}
+	return a
+}
+
+func sameRate(a, b string) bool {
+	return parseAmount(a).Compare(parseAmount(b)) == 0
+}
+
+func containsRate(list []string, rate string) bool {
+	return slices.ContainsFunc(list, func(r string) bool { return sameRate(r, rate) })
+}
@@ -0,0 +1,241 @@
+package verifactu_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRegistration(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts))
+	require.NoError(t, err)
+
+	load := func(t *testing.T, name string) *verifactu.InvoiceRegistration {
+		t.Helper()
+		reg, err := c.RegisterInvoice(test.LoadEnvelope(name), nil)
+		require.NoError(t, err)
+		return reg
+	}
+
+	codes := func(faults rules.Faults) []string {
+		if faults == nil {
+			return nil
+		}
+		var out []string
+		for _, f := range faults.List() {
+			ec := verifactu.FaultErrorCode(f)
+			require.NotNil(t, ec, f.Code())
+			out = append(out, ec.Code)
+		}
+		return out
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		for _, name := range []string{
+			"inv-base.json",
+			"inv-simplified.json",
+			"inv-eqv-sur.json",
+			"inv-eu-b2c.json",
+			"inv-rebu.json",
+			"cred-note-base.json",
+			"inv-igic.json",
+			"inv-ipsi.json",
+			"inv-vat-igic.json",
+		} {
+			assert.Nil(t, verifactu.ValidateRegistration(load(t, name)), name)
+		}
+	})
+
+	t.Run("recipients", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.Destinatarios = nil
+		assert.Equal(t, []string{"1189"}, codes(verifactu.ValidateRegistration(reg)))
+
+		simple := load(t, "inv-simplified.json")
+		simple.Destinatarios = load(t, "inv-base.json").Destinatarios
+		assert.Equal(t, []string{"1190"}, codes(verifactu.ValidateRegistration(simple)))
+	})
+
+	t.Run("simplified limit", func(t *testing.T) {
+		reg := load(t, "inv-simplified.json")
+		reg.Desglose.DetalleDesglose[0].BaseImponibleOImporteNoSujeto = "3000.00"
+		reg.FacturaSinIdentifDestinatarioArt61d = "N"
+		faults := verifactu.ValidateRegistration(reg)
+		assert.Contains(t, codes(faults), "1150")
+
+		reg.NumRegistroAcuerdoFacturacion = "12345"
+		assert.NotContains(t, codes(verifactu.ValidateRegistration(reg)), "1150")
+	})
+
+	t.Run("corrective fields", func(t *testing.T) {
+		reg := load(t, "cred-note-base.json")
+		reg.TipoRectificativa = ""
+		assert.Equal(t, []string{"1114"}, codes(verifactu.ValidateRegistration(reg)))
+
+		reg.TipoRectificativa = "S"
+		assert.Equal(t, []string{"1118"}, codes(verifactu.ValidateRegistration(reg)))
+
+		reg = load(t, "inv-base.json")
+		reg.TipoRectificativa = "I"
+		reg.FacturasRectificadas = &verifactu.FacturasRectificadas{}
+		reg.FacturasSustituidas = &verifactu.FacturasSustituidas{}
+		reg.ImporteRectificacion = &verifactu.ImporteRectificacion{}
+		assert.ElementsMatch(t, []string{"1115", "1116", "1117", "1119"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("coupon", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.Cupon = "S"
+		assert.Equal(t, []string{"1157"}, codes(verifactu.ValidateRegistration(reg)))
+
+		reg = load(t, "cred-note-base.json")
+		reg.Cupon = "S"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+	})
+
+	t.Run("agreement", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.IdAcuerdoSistemaInformatico = "SIS01"
+		faults := verifactu.ValidateRegistration(reg)
+		require.Len(t, faults.List(), 1)
+		assert.True(t, strings.HasSuffix(string(faults.List()[0].Code()), "-L02"))
+
+		reg.NumRegistroAcuerdoFacturacion = "AC0000000001"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+	})
+
+	t.Run("tax rates", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.Desglose.DetalleDesglose[0].TipoImpositivo = "22"
+		faults := verifactu.ValidateRegistration(reg)
+		require.NotNil(t, faults)
+		assert.Equal(t, []string{"1124"}, codes(faults))
+		assert.Contains(t, faults.Error(), "tax rate not valid")
+
+		reg = load(t, "inv-eqv-sur.json")
+		reg.Desglose.DetalleDesglose[0].TipoRecargoEquivalencia = "1.4"
+		assert.Equal(t, []string{"1162"}, codes(verifactu.ValidateRegistration(reg)))
+
+		reg = load(t, "inv-igic.json")
+		reg.Desglose.DetalleDesglose[0].TipoImpositivo = "21"
+		assert.Equal(t, []string{"1124"}, codes(verifactu.ValidateRegistration(reg)))
+
+		reg = load(t, "inv-ipsi.json")
+		reg.Desglose.DetalleDesglose[0].TipoImpositivo = "21"
+		assert.Equal(t, []string{"1124"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("compensation rates", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		dd := reg.Desglose.DetalleDesglose[0]
+		dd.ClaveRegimen = "19"
+		dd.TipoImpositivo = "12"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+		dd.TipoImpositivo = "10.5"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+
+		dd.TipoImpositivo = "21"
+		faults := verifactu.ValidateRegistration(reg)
+		assert.Equal(t, []string{"1124"}, codes(faults))
+		assert.Contains(t, faults.Error(), "tax rate not valid for the tax and regime")
+
+		dd.ClaveRegimen = "01"
+		dd.TipoImpositivo = "12"
+		assert.Equal(t, []string{"1124"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("zero rate", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.Desglose.DetalleDesglose[0].TipoImpositivo = "0"
+		faults := verifactu.ValidateRegistration(reg)
+		assert.Equal(t, []string{"1124"}, codes(faults))
+		assert.Contains(t, faults.Error(), "zero VAT rate only allowed")
+
+		// temporary rate on basic foods
+		reg.IDFactura.FechaExpedicionFactura = "13-11-2023"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+
+		assert.Nil(t, verifactu.ValidateRegistration(load(t, "inv-rev-charge.json")))
+		assert.Nil(t, verifactu.ValidateRegistration(load(t, "inv-zero-tax.json")))
+
+		// IGIC has a taxed zero rate
+		reg = load(t, "inv-igic.json")
+		reg.Desglose.DetalleDesglose[0].TipoImpositivo = "0"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+	})
+
+	t.Run("surcharge outside regime", func(t *testing.T) {
+		reg := load(t, "inv-eqv-sur.json")
+		reg.Desglose.DetalleDesglose[0].ClaveRegimen = "18"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+
+		reg.Desglose.DetalleDesglose[0].ClaveRegimen = "04"
+		faults := verifactu.ValidateRegistration(reg)
+		require.Len(t, faults.List(), 1)
+		f := faults.List()[0]
+		assert.True(t, strings.HasSuffix(string(f.Code()), "-L01"), f.Code())
+		assert.Nil(t, verifactu.FaultErrorCode(f))
+		assert.Contains(t, faults.Error(), "equivalence surcharge regimes")
+	})
+
+	t.Run("surcharge outside VAT", func(t *testing.T) {
+		reg := load(t, "inv-igic.json")
+		reg.Desglose.DetalleDesglose[0].TipoRecargoEquivalencia = "1.4"
+		assert.Equal(t, []string{"1127"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("igic retail traders", func(t *testing.T) {
+		// Regime 17 is OSS for VAT, but retail traders in IGIC, so totals
+		// are still checked.
+		reg := load(t, "inv-igic.json")
+		for _, dd := range reg.Desglose.DetalleDesglose {
+			dd.ClaveRegimen = "17"
+		}
+		reg.CuotaTotal = reg.CuotaTotal.Add(num.MakeAmount(5000, 2))
+		assert.Equal(t, []string{"2006"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("totals", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.ImporteTotal = reg.ImporteTotal.Add(num.MakeAmount(500, 2))
+		assert.Nil(t, verifactu.ValidateRegistration(reg), "within tolerance")
+
+		reg.ImporteTotal = reg.ImporteTotal.Add(num.MakeAmount(1000, 2))
+		reg.CuotaTotal = reg.CuotaTotal.Add(num.MakeAmount(1001, 2))
+		assert.Equal(t, []string{"2005", "2006"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("dates", func(t *testing.T) {
+		reg := load(t, "inv-op-date.json")
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+
+		reg.FechaOperacion = "27-11-2024"
+		assert.Equal(t, []string{"1125"}, codes(verifactu.ValidateRegistration(reg)))
+
+		reg.Desglose.DetalleDesglose[0].ClaveRegimen = "14"
+		assert.Nil(t, verifactu.ValidateRegistration(reg))
+
+		reg.IDFactura.FechaExpedicionFactura = "27-11-2024"
+		assert.Equal(t, []string{"1112"}, codes(verifactu.ValidateRegistration(reg)))
+	})
+
+	t.Run("error code details", func(t *testing.T) {
+		reg := load(t, "inv-base.json")
+		reg.Destinatarios = nil
+		faults := verifactu.ValidateRegistration(reg)
+		require.NotNil(t, faults)
+		ec := verifactu.FaultErrorCode(faults.First())
+		require.NotNil(t, ec)
+		assert.Equal(t, verifactu.CategoryRejection, ec.Category)
+		assert.Equal(t, verifactu.RemedyResend, ec.Remedy)
+	})
+}
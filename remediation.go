@@ -0,0 +1,126 @@
+package verifactu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/invopop/gobl"
+)
+
+// rejectedAmendment is used internally to amend a record that exists in
+// the AEAT after an earlier amendment was rejected.
+func rejectedAmendment() GenerateOption {
+	return func(o *generateOptions) {
+		o.amendment = "S"
+		o.previouslyRejected = "S"
+	}
+}
+
+// Remediate prepares the follow-up record needed to resolve the problem
+// reported by the AEAT for a submitted record. The envelope must contain the
+// original invoice, and prev the chain data of the last record generated by the
+// system, which will usually be different from the one used by the original.
+//
+// Registrations accepted with errors are amended, while rejected registrations
+// are sent again flagged as previously rejected. Cancellations of records the
+// AEAT does not have are re-issued without a prior record. An ErrNotRemediable
+// error is returned when the record was accepted, no response was received,
+// or the error code indicates a new record will not help.
+func (c *Client) Remediate(env *gobl.Envelope, res *InvoiceResult, prev *ChainData) (*InvoiceRequestLine, error) {
+	if res == nil || res.Request == nil {
+		return nil, ErrNotRemediable.WithMessage("missing request")
+	}
+	line := res.Response
+	if line == nil {
+		return nil, ErrNotRemediable.WithMessage("missing response, query the record state first")
+	}
+	if line.Error() == nil {
+		return nil, ErrNotRemediable.WithMessage("record accepted")
+	}
+	if ec := LookupErrorCode(line.Code); ec != nil && (ec.Remedy == RemedyNone || ec.Remedy == RemedyConfigure) {
+		return nil, ErrNotRemediable.WithCode(line.Code).WithMessage(line.Message())
+	}
+	if line.Duplicated != nil {
+		return nil, ErrNotRemediable.WithCode(line.Code).WithMessage("duplicated record")
+	}
+
+	switch {
+	case res.Request.Registration != nil:
+		reg, err := c.RegisterInvoice(env, prev, registrationRemedy(res.Request.Registration, line))
+		if err != nil {
+			return nil, err
+		}
+		return &InvoiceRequestLine{Registration: reg}, nil
+	case res.Request.Cancellation != nil:
+		opt, err := cancellationRemedy(line)
+		if err != nil {
+			return nil, err
+		}
+		can, err := c.CancelInvoice(env, prev, opt)
+		if err != nil {
+			return nil, err
+		}
+		return &InvoiceRequestLine{Cancellation: can}, nil
+	}
+	return nil, ErrNotRemediable.WithMessage("empty request line")
+}
+
+func registrationRemedy(reg *InvoiceRegistration, line *InvoiceResponseLine) GenerateOption {
+	switch {
+	case line.Status == StatusAcceptedWithErrors:
+		return Amended()
+	case reg.Subsanacion != "S", reg.RechazoPrevio == "X":
+		// The record was never registered by the AEAT
+		return PreviouslyRejected()
+	default:
+		// An amendment of an existing record was rejected
+		return rejectedAmendment()
+	}
+}
+
+func cancellationRemedy(line *InvoiceResponseLine) (GenerateOption, error) {
+	if line.Status == StatusAcceptedWithErrors {
+		return nil, ErrNotRemediable.WithCode(line.Code).WithMessage("cancellations cannot be amended")
+	}
+	if line.Code == "3002" {
+		// The AEAT has no record of the invoice being cancelled
+		return NoPriorRecord(), nil
+	}
+	return PreviouslyRejected(), nil
+}
+
+// RemediateRequest prepares a new request containing follow-up records for each
+// of the lines in the original request that failed. Envelopes must be provided in
+// the same order as the request lines. Follow-up records are chained in sequence
+// starting from prev. Lines that cannot be remediated are skipped and their errors
+// joined in the error returned alongside the new request. A nil request will be
+// returned if there is nothing to send.
+func (c *Client) RemediateRequest(ir *InvoiceRequest, res *InvoiceResponse, envs []*gobl.Envelope, prev *ChainData) (*InvoiceRequest, error) {
+	if len(envs) != len(ir.Lines) {
+		return nil, ErrValidation.WithMessage(
+			fmt.Sprintf("expected %d envelopes, got %d", len(ir.Lines), len(envs)),
+		)
+	}
+	if err := c.checkRequestEnvironment(ir); err != nil {
+		return nil, err
+	}
+
+	var out *InvoiceRequest
+	var errs []error
+	for i, r := range res.Results(ir) {
+		if r.Response != nil && r.Response.Error() == nil {
+			continue
+		}
+		line, err := c.Remediate(envs[i], r, prev)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i, err))
+			continue
+		}
+		if out == nil {
+			out = &InvoiceRequest{Header: ir.Header.clone(), env: c.env}
+		}
+		out.AddLine(line)
+		prev = line.ChainData()
+	}
+	return out, errors.Join(errs...)
+}
@@ -0,0 +1,196 @@
+package verifactu_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/invopop/gobl"
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemediate(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+
+	setup := func(t *testing.T) (*verifactutest.Server, *verifactu.Client) {
+		t.Helper()
+		srv := verifactutest.NewServer()
+		t.Cleanup(srv.Close)
+		c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL))
+		require.NoError(t, err)
+		return srv, c
+	}
+
+	send := func(t *testing.T, c *verifactu.Client, env *gobl.Envelope, lines ...*verifactu.InvoiceRequestLine) []*verifactu.InvoiceResult {
+		t.Helper()
+		inv := env.Extract().(*bill.Invoice)
+		ir, err := c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		for _, l := range lines {
+			ir.AddLine(l)
+		}
+		res, _ := c.SendInvoiceRequest(context.Background(), ir)
+		require.NotNil(t, res)
+		return res.Results(ir)
+	}
+
+	t.Run("accepted with errors", func(t *testing.T) {
+		srv, c := setup(t)
+		srv.SetOutcome("SAMPLE-004", &verifactutest.Outcome{
+			Status: verifactu.StatusAcceptedWithErrors,
+			Code:   "2000",
+		})
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		results := send(t, c, env, &verifactu.InvoiceRequestLine{Registration: reg})
+
+		line, err := c.Remediate(env, results[0], reg.ChainData())
+		require.NoError(t, err)
+		require.NotNil(t, line.Registration)
+		assert.Equal(t, "S", line.Registration.Subsanacion)
+		assert.Empty(t, line.Registration.RechazoPrevio)
+		assert.Equal(t, reg.Huella, line.Registration.Encadenamiento.RegistroAnterior.Huella)
+
+		results = send(t, c, env, line)
+		assert.NoError(t, results[0].Error())
+	})
+
+	t.Run("rejected registration", func(t *testing.T) {
+		srv, c := setup(t)
+		srv.SetOutcome("SAMPLE-004", &verifactutest.Outcome{
+			Status: verifactu.StatusIncorrect,
+			Code:   "1100",
+		})
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		results := send(t, c, env, &verifactu.InvoiceRequestLine{Registration: reg})
+
+		line, err := c.Remediate(env, results[0], reg.ChainData())
+		require.NoError(t, err)
+		assert.Equal(t, "S", line.Registration.Subsanacion)
+		assert.Equal(t, "X", line.Registration.RechazoPrevio)
+
+		results = send(t, c, env, line)
+		assert.NoError(t, results[0].Error())
+	})
+
+	t.Run("cancellation without record", func(t *testing.T) {
+		_, c := setup(t)
+		env := test.LoadEnvelope("inv-base.json")
+		can, err := c.CancelInvoice(env, nil)
+		require.NoError(t, err)
+		results := send(t, c, env, &verifactu.InvoiceRequestLine{Cancellation: can})
+		require.Equal(t, "3002", results[0].Response.Code)
+
+		line, err := c.Remediate(env, results[0], can.ChainData())
+		require.NoError(t, err)
+		require.NotNil(t, line.Cancellation)
+		assert.Equal(t, "S", line.Cancellation.SinRegistroPrevio)
+		assert.Empty(t, line.Cancellation.RechazoPrevio)
+
+		results = send(t, c, env, line)
+		assert.NoError(t, results[0].Error())
+	})
+
+	t.Run("rejected cancellation", func(t *testing.T) {
+		srv, c := setup(t)
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		send(t, c, env, &verifactu.InvoiceRequestLine{Registration: reg})
+
+		srv.SetOutcome("SAMPLE-004", &verifactutest.Outcome{
+			Status: verifactu.StatusIncorrect,
+			Code:   "1100",
+		})
+		can, err := c.CancelInvoice(env, reg.ChainData())
+		require.NoError(t, err)
+		results := send(t, c, env, &verifactu.InvoiceRequestLine{Cancellation: can})
+
+		line, err := c.Remediate(env, results[0], can.ChainData())
+		require.NoError(t, err)
+		assert.Equal(t, "S", line.Cancellation.RechazoPrevio)
+
+		results = send(t, c, env, line)
+		assert.NoError(t, results[0].Error())
+	})
+
+	t.Run("not remediable", func(t *testing.T) {
+		_, c := setup(t)
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		results := send(t, c, env, &verifactu.InvoiceRequestLine{Registration: reg})
+
+		_, err = c.Remediate(env, results[0], reg.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrNotRemediable, "accepted")
+
+		results = send(t, c, env, &verifactu.InvoiceRequestLine{Registration: reg})
+		_, err = c.Remediate(env, results[0], reg.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrNotRemediable, "duplicate")
+
+		_, err = c.Remediate(env, &verifactu.InvoiceResult{Request: results[0].Request}, reg.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrNotRemediable, "missing")
+
+		res := &verifactu.InvoiceResult{
+			Request:  results[0].Request,
+			Response: &verifactu.InvoiceResponseLine{Status: verifactu.StatusIncorrect, Code: "4112"},
+		}
+		_, err = c.Remediate(env, res, reg.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrNotRemediable, "permissions")
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		srv, c := setup(t)
+		env1 := test.LoadEnvelope("inv-base.json")
+		env2 := test.LoadEnvelope("inv-tax-inc.json")
+		reg1, err := c.RegisterInvoice(env1, nil)
+		require.NoError(t, err)
+		reg2, err := c.RegisterInvoice(env2, reg1.ChainData())
+		require.NoError(t, err)
+
+		srv.SetOutcome("SAMPLE-003", &verifactutest.Outcome{
+			Status: verifactu.StatusIncorrect,
+			Code:   "1100",
+		})
+		ir, err := c.NewInvoiceRequest(env1.Extract().(*bill.Invoice).Supplier)
+		require.NoError(t, err)
+		ir.AddRegistration(reg1)
+		ir.AddRegistration(reg2)
+		res, err := c.SendInvoiceRequest(context.Background(), ir)
+		require.Error(t, err)
+
+		_, err = c.RemediateRequest(ir, res, []*gobl.Envelope{env1}, reg2.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+
+		next, err := c.RemediateRequest(ir, res, []*gobl.Envelope{env1, env2}, reg2.ChainData())
+		require.NoError(t, err)
+		require.NotNil(t, next)
+		require.Len(t, next.Lines, 1)
+		assert.Equal(t, verifactu.EnvironmentSandbox, next.Environment())
+		require.NotSame(t, ir.Header, next.Header)
+		assert.Equal(t, *ir.Header, *next.Header)
+		next.Header.Obligado.NombreRazon = "Changed"
+		assert.NotEqual(t, "Changed", ir.Header.Obligado.NombreRazon)
+		assert.Equal(t, "SAMPLE-003", next.Lines[0].Registration.IDFactura.NumSerieFactura)
+		assert.Equal(t, "X", next.Lines[0].Registration.RechazoPrevio)
+		assert.Equal(t, reg2.Huella, next.Lines[0].Registration.Encadenamiento.RegistroAnterior.Huella)
+
+		res, err = c.SendInvoiceRequest(context.Background(), next)
+		require.NoError(t, err)
+		assert.Equal(t, verifactu.SubmissionCorrect, res.Status)
+
+		prod, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL), verifactu.InProduction())
+		require.NoError(t, err)
+		_, err = prod.RemediateRequest(ir, res, []*gobl.Envelope{env1, env2}, reg2.ChainData())
+		assert.ErrorIs(t, err, verifactu.ErrEnvironment)
+	})
+}
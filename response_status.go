@@ -0,0 +1,175 @@
+package verifactu
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+)
+
+// Status line keys used to describe the result of each submitted record.
+const (
+	StatusKeyAccepted cbc.Key = "accepted"
+	StatusKeyWarning  cbc.Key = "warning"
+	StatusKeyRejected cbc.Key = "rejected"
+	StatusKeyMissing  cbc.Key = "missing"
+)
+
+// Meta keys used in the status documents.
+const (
+	metaKeySubmission cbc.Key = "submission-status"
+	metaKeyOperation  cbc.Key = "operation"
+	metaKeyRecord     cbc.Key = "record-status"
+	metaKeyRef        cbc.Key = "ref"
+)
+
+// aeat is the party issuing the responses.
+var aeat = &org.Party{
+	Name: "Agencia Estatal de Administración Tributaria",
+	TaxID: &tax.Identity{
+		Country: l10n.ES.Tax(),
+		Code:    "Q2826000H",
+	},
+}
+
+// ResponseStatus converts the response to the request into a GOBL bill status
+// envelope issued by the AEAT to the obligated party, with one line per
+// record in the request. Each line references the invoice and includes the
+// record's fingerprint as a stamp, alongside the AEAT error code, if any, as a
+// reason condition and the action needed to resolve it.
+func (c *Client) ResponseStatus(req *InvoiceRequest, res *InvoiceResponse) (*gobl.Envelope, error) {
+	if req == nil || req.Header == nil || len(req.Lines) == 0 {
+		return nil, ErrValidation.WithMessage("missing request lines")
+	}
+	h := req.Header
+	status := &bill.Status{
+		Regime:    tax.WithRegime(l10n.ES.Tax()),
+		Type:      bill.StatusTypeResponse,
+		IssueDate: cal.DateOf(c.CurrentTime().In(c.Location())),
+		Issuer:    aeat,
+		Supplier: &org.Party{
+			Name: h.Obligado.NombreRazon,
+			TaxID: &tax.Identity{
+				Country: l10n.ES.Tax(),
+				Code:    cbc.Code(h.Obligado.NIF),
+			},
+		},
+	}
+	if res != nil && res.Status != "" {
+		status.Meta = cbc.Meta{metaKeySubmission: string(res.Status)}
+	}
+	for _, r := range res.Results(req) {
+		status.Lines = append(status.Lines, resultStatusLine(r))
+	}
+	env, err := gobl.Envelop(status)
+	if err != nil {
+		return nil, fmt.Errorf("preparing status envelope: %w", err)
+	}
+	return env, nil
+}
+
+// resultStatusLine describes the result of a single record.
+func resultStatusLine(r *InvoiceResult) *bill.StatusLine {
+	line := &bill.StatusLine{
+		Key:  resultStatusKey(r),
+		Doc:  resultDocRef(r.Request),
+		Meta: cbc.Meta{},
+	}
+	if reg := r.Request.Registration; reg != nil {
+		line.Meta[metaKeyOperation] = string(OpTypeRegistration)
+	} else {
+		line.Meta[metaKeyOperation] = string(OpTypeCancellation)
+	}
+	if ref := r.Ref(); ref != "" {
+		line.Meta[metaKeyRef] = ref
+	}
+	if r.Missing() {
+		line.Description = "No response provided for the record"
+		return line
+	}
+	line.Meta[metaKeyRecord] = r.Response.Status
+	line.Description = r.Response.Message()
+	if r.Response.Code == "" {
+		return line
+	}
+
+	reason := &bill.Reason{
+		Key: bill.ReasonKeyLegal,
+		Conditions: []*bill.Condition{
+			{
+				Code:    cbc.Code(r.Response.Code),
+				Message: r.Response.Description,
+			},
+		},
+	}
+	action := &bill.Action{Key: bill.ActionKeyNone}
+	if ec := LookupErrorCode(r.Response.Code); ec != nil {
+		reason.Description = ec.Description.In(i18n.ES)
+		switch ec.Remedy {
+		case RemedyAmend:
+			action = &bill.Action{Key: bill.ActionKeyReissue, Description: "Send an amendment (Subsanación) of the record"}
+		case RemedyResend:
+			action = &bill.Action{Key: bill.ActionKeyReissue, Description: "Fix the data and send the record again"}
+		case RemedyConfigure:
+			action = &bill.Action{Key: bill.ActionKeyOther, Description: "Fix the certificate or representation permissions before sending again"}
+		}
+	}
+	line.Reasons = []*bill.Reason{reason}
+	line.Actions = []*bill.Action{action}
+	return line
+}
+
+func resultStatusKey(r *InvoiceResult) cbc.Key {
+	if r.Missing() {
+		return StatusKeyMissing
+	}
+	switch r.Response.Status {
+	case StatusCorrect, StatusCancelled:
+		return StatusKeyAccepted
+	case StatusAcceptedWithErrors:
+		return StatusKeyWarning
+	}
+	return StatusKeyRejected
+}
+
+// resultDocRef references the invoice of the record, including its
+// fingerprint as a stamp.
+func resultDocRef(line *InvoiceRequestLine) *org.DocumentRef {
+	rec := line.Record()
+	if rec == nil {
+		return nil
+	}
+	id := rec.Identity()
+	if id.Code == "" {
+		return nil
+	}
+	key, hash := StampKeyHash, rec.Fingerprint()
+	if rec.Kind() == RecordKindCancellation {
+		key = StampKeyCancelHash
+	}
+	ref := &org.DocumentRef{
+		Code: cbc.Code(id.Code),
+		Identities: []*org.Identity{
+			{
+				Type: "NIF",
+				Code: cbc.Code(id.Issuer),
+			},
+		},
+	}
+	if t, err := time.Parse("02-01-2006", id.Date); err == nil {
+		d := cal.DateOf(t)
+		ref.IssueDate = &d
+	}
+	if hash != "" {
+		ref.Stamps = []*head.Stamp{{Provider: key, Value: hash}}
+	}
+	return ref
+}
@@ -0,0 +1,138 @@
+package verifactu_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/invopop/gobl"
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseStatus(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	srv := verifactutest.NewServer()
+	t.Cleanup(srv.Close)
+	c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL))
+	require.NoError(t, err)
+
+	// register the invoice to cancel first
+	env, inv := test.LoadInvoice("inv-tax-inc.json")
+	prev, err := c.NewEnvelopeInvoiceRequest(env, nil)
+	require.NoError(t, err)
+	_, err = c.SendInvoiceRequest(context.Background(), prev)
+	require.NoError(t, err)
+
+	reg, err := c.RegisterInvoice(test.LoadEnvelope("inv-base.json"), prev.Lines[0].ChainData(), verifactu.WithRef("REF-1"))
+	require.NoError(t, err)
+	can, err := c.CancelInvoice(env, reg.ChainData())
+	require.NoError(t, err)
+	ir, err := c.NewInvoiceRequest(inv.Supplier)
+	require.NoError(t, err)
+	ir.AddRegistration(reg)
+	ir.AddCancellation(can)
+
+	t.Run("accepted", func(t *testing.T) {
+		res, err := c.SendInvoiceRequest(context.Background(), ir)
+		require.NoError(t, err)
+		out, err := c.ResponseStatus(ir, res)
+		require.NoError(t, err)
+
+		st, ok := out.Extract().(*bill.Status)
+		require.True(t, ok)
+		assert.Equal(t, bill.StatusTypeResponse, st.Type)
+		assert.Equal(t, "2024-11-26", st.IssueDate.String())
+		assert.Equal(t, "B85905495", st.Supplier.TaxID.Code.String())
+		assert.Equal(t, "Q2826000H", st.Issuer.TaxID.Code.String())
+		require.Len(t, st.Lines, 2)
+
+		l := st.Lines[0]
+		assert.Equal(t, verifactu.StatusKeyAccepted, l.Key)
+		assert.Equal(t, "SAMPLE-004", l.Doc.Code.String())
+		assert.Equal(t, "2024-11-13", l.Doc.IssueDate.String())
+		require.Len(t, l.Doc.Stamps, 1)
+		assert.Equal(t, verifactu.StampKeyHash, l.Doc.Stamps[0].Provider)
+		assert.Equal(t, reg.Huella, l.Doc.Stamps[0].Value)
+		assert.Equal(t, "REF-1", l.Meta["ref"])
+		assert.Equal(t, "Alta", l.Meta["operation"])
+		assert.Empty(t, l.Reasons)
+
+		l = st.Lines[1]
+		assert.Equal(t, verifactu.StatusKeyAccepted, l.Key)
+		assert.Equal(t, "SAMPLE-003", l.Doc.Code.String())
+		assert.Equal(t, verifactu.StampKeyCancelHash, l.Doc.Stamps[0].Provider)
+		assert.Equal(t, "Anulacion", l.Meta["operation"])
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		res := &verifactu.InvoiceResponse{
+			Status: verifactu.SubmissionPartiallyCorrect,
+			Lines: []*verifactu.InvoiceResponseLine{
+				{
+					Ref:         "REF-1",
+					Status:      verifactu.StatusAcceptedWithErrors,
+					Code:        "2000",
+					Description: "El cálculo de la huella suministrada es incorrecta.",
+				},
+			},
+		}
+		res.Lines[0].Operation.Type = verifactu.OpTypeRegistration
+		out, err := c.ResponseStatus(ir, res)
+		require.NoError(t, err)
+		st := out.Extract().(*bill.Status)
+		assert.Equal(t, "ParcialmenteCorrecto", st.Meta["submission-status"])
+		require.Len(t, st.Lines, 2)
+
+		l := st.Lines[0]
+		assert.Equal(t, verifactu.StatusKeyWarning, l.Key)
+		assert.Equal(t, "AceptadoConErrores", l.Meta["record-status"])
+		require.Len(t, l.Reasons, 1)
+		assert.Equal(t, bill.ReasonKeyLegal, l.Reasons[0].Key)
+		assert.Equal(t, "2000", l.Reasons[0].Conditions[0].Code.String())
+		require.Len(t, l.Actions, 1)
+		assert.Equal(t, bill.ActionKeyReissue, l.Actions[0].Key)
+
+		assert.Equal(t, verifactu.StatusKeyMissing, st.Lines[1].Key)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		res := &verifactu.InvoiceResponse{
+			Status: verifactu.SubmissionIncorrect,
+			Lines: []*verifactu.InvoiceResponseLine{
+				{Ref: "REF-1", Status: verifactu.StatusIncorrect, Code: "1100"},
+			},
+		}
+		res.Lines[0].Operation.Type = verifactu.OpTypeRegistration
+		out, err := c.ResponseStatus(ir, res)
+		require.NoError(t, err)
+		st := out.Extract().(*bill.Status)
+		assert.Equal(t, verifactu.StatusKeyRejected, st.Lines[0].Key)
+
+		// the envelope must survive a round trip through GOBL
+		data, err := json.Marshal(out)
+		require.NoError(t, err)
+		env := new(gobl.Envelope)
+		require.NoError(t, json.Unmarshal(data, env))
+		require.NoError(t, env.Validate())
+		st, ok := env.Extract().(*bill.Status)
+		require.True(t, ok)
+		l := st.Lines[0]
+		assert.Equal(t, verifactu.StatusKeyRejected, l.Key)
+		assert.Equal(t, "Incorrecto", l.Meta["record-status"])
+		require.Len(t, l.Reasons, 1)
+		assert.Equal(t, "1100", l.Reasons[0].Conditions[0].Code.String())
+		require.Len(t, l.Actions, 1)
+	})
+
+	t.Run("empty request", func(t *testing.T) {
+		_, err := c.ResponseStatus(&verifactu.InvoiceRequest{}, nil)
+		assert.ErrorIs(t, err, verifactu.ErrValidation)
+	})
+}
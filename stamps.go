@@ -0,0 +1,202 @@
+package verifactu
+
+import (
+	"github.com/invopop/gobl"
+	"github.com/invopop/gobl/addons/es/verifactu"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/head"
+)
+
+// Additional stamp keys used to record the state of the invoice in the
+// envelope, so that it can be used as the source of truth for the chain.
+const (
+	// StampKeyCancelHash contains the fingerprint of the cancellation.
+	StampKeyCancelHash cbc.Key = "verifactu-cancel-hash"
+	// StampKeyGenerated contains the generation timestamp of the latest
+	// record, as included in FechaHoraHusoGenRegistro.
+	StampKeyGenerated cbc.Key = "verifactu-generated"
+	// StampKeyEnvironment contains the environment the latest record was
+	// generated and submitted in.
+	StampKeyEnvironment cbc.Key = "verifactu-env"
+	// StampKeyStatus contains the status of the latest record provided by
+	// the AEAT, as in EstadoRegistro.
+	StampKeyStatus cbc.Key = "verifactu-status"
+	// StampKeyCode contains the error code of the latest record provided
+	// by the AEAT, if any.
+	StampKeyCode cbc.Key = "verifactu-code"
+	// StampKeySubmitted contains the time the AEAT received the submission,
+	// as in TimestampPresentacion, or the time the response was processed if
+	// not provided.
+	StampKeySubmitted cbc.Key = "verifactu-submitted"
+	// StampKeyCSV contains the secure verification code assigned by the AEAT
+	// to the submission, if any.
+	StampKeyCSV cbc.Key = "verifactu-csv"
+)
+
+// outcomeStampKeys are removed when a new record is generated, as they
+// refer to the submission of the previous one.
+var outcomeStampKeys = []cbc.Key{StampKeyStatus, StampKeyCode, StampKeySubmitted, StampKeyCSV}
+
+// addEventStamps adds the Hash stamp to the envelope for event registrations.
+func (c *Client) addEventStamps(env *gobl.Envelope, reg *EventRegistration) {
+	if reg.Event != nil {
+		env.Head.AddStamp(&head.Stamp{
+			Provider: StampKeyHash,
+			Value:    reg.Event.Fingerprint,
+		})
+	}
+}
+
+// addRegistrationStamps adds the QR code stamp and Hash to the envelope.
+func (c *Client) addRegistrationStamps(env *gobl.Envelope, reg *InvoiceRegistration) {
+	// now generate the QR codes and add them to the envelope
+	code := c.QRURL(reg)
+	env.Head.AddStamp(&head.Stamp{
+		Provider: verifactu.StampQR,
+		Value:    code,
+	})
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeyHash,
+		Value:    reg.Huella,
+	})
+	removeStamps(env, append([]cbc.Key{StampKeyCancelHash}, outcomeStampKeys...)...)
+	c.addRecordStamps(env, reg.FechaHoraHusoGenRegistro)
+}
+
+// addCancellationStamps adds the cancellation Hash to the envelope, keeping
+// the stamps of the original registration.
+func (c *Client) addCancellationStamps(env *gobl.Envelope, can *InvoiceCancellation) {
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeyCancelHash,
+		Value:    can.Huella,
+	})
+	removeStamps(env, outcomeStampKeys...)
+	c.addRecordStamps(env, can.FechaHoraHusoGenRegistro)
+}
+
+func (c *Client) addRecordStamps(env *gobl.Envelope, ts string) {
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeyGenerated,
+		Value:    ts,
+	})
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeyEnvironment,
+		Value:    string(c.env),
+	})
+}
+
+// AddResultStamps records the outcome of submitting the envelope's latest
+// record to the AEAT: the status, error code if any, the AEAT's receipt with
+// the CSV and presentation time, and the environment. The result must belong to the record
+// stamped in the envelope. Results without a response are not stamped.
+func (c *Client) AddResultStamps(env *gobl.Envelope, res *InvoiceResult) error {
+	if res == nil || res.Request == nil || res.Request.Record() == nil {
+		return ErrValidation.WithMessage("missing result")
+	}
+	rec := res.Request.Record()
+	key, hash := StampKeyHash, rec.Fingerprint()
+	if rec.Kind() == RecordKindCancellation {
+		key = StampKeyCancelHash
+	}
+	st := env.Head.GetStamp(key)
+	if key == StampKeyHash && env.Head.GetStamp(StampKeyCancelHash) != nil {
+		// the registration was followed by a cancellation
+		st = nil
+	}
+	if st == nil || st.Value != hash {
+		return ErrValidation.WithMessage("result does not match the record stamped in the envelope")
+	}
+	if err := c.checkEnvironment(res.Request.Environment(), "record"); err != nil {
+		return err
+	}
+	if err := c.checkEnvironment(EnvelopeEnvironment(env), "envelope"); err != nil {
+		return err
+	}
+	if res.Missing() {
+		return nil
+	}
+
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeyStatus,
+		Value:    res.Response.Status,
+	})
+	if res.Response.Code != "" {
+		env.Head.AddStamp(&head.Stamp{
+			Provider: StampKeyCode,
+			Value:    res.Response.Code,
+		})
+	} else {
+		removeStamps(env, StampKeyCode)
+	}
+	submitted := formatDateTimeZone(c.CurrentTime().In(c.Location()))
+	if p := res.Presentation; p != nil && p.Timestamp != "" {
+		submitted = p.Timestamp
+	}
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeySubmitted,
+		Value:    submitted,
+	})
+	if res.CSV != "" {
+		env.Head.AddStamp(&head.Stamp{
+			Provider: StampKeyCSV,
+			Value:    res.CSV,
+		})
+	} else {
+		removeStamps(env, StampKeyCSV)
+	}
+	env.Head.AddStamp(&head.Stamp{
+		Provider: StampKeyEnvironment,
+		Value:    string(c.env),
+	})
+	return nil
+}
+
+// EnvelopeChainData recovers the chain data of the latest record generated
+// for the invoice in the envelope, using the stamps added when the record
+// was generated. The cancellation is used when present, as it is always
+// generated after the registration.
+func EnvelopeChainData(env *gobl.Envelope) (*ChainData, error) {
+	inv, ok := env.Extract().(*bill.Invoice)
+	if !ok {
+		return nil, ErrOnlyInvoices
+	}
+	if inv.Supplier == nil || inv.Supplier.TaxID == nil {
+		return nil, ErrValidation.WithMessage("missing supplier or tax id")
+	}
+	st := env.Head.GetStamp(StampKeyCancelHash)
+	if st == nil {
+		st = env.Head.GetStamp(StampKeyHash)
+	}
+	if st == nil {
+		return nil, ErrMissing.WithMessage("envelope does not contain a record hash stamp")
+	}
+
+	// Identify the invoice in the same way as the records
+	can := newInvoiceCancellation(inv, inv.IssueDate.Time(), nil)
+	if err := can.normalize(); err != nil {
+		return nil, err
+	}
+	cd := &ChainData{
+		IDIssuer:    can.IDFactura.IDEmisorFactura,
+		NumSeries:   can.IDFactura.NumSerieFactura,
+		IssueDate:   can.IDFactura.FechaExpedicionFactura,
+		Fingerprint: st.Value,
+	}
+	if st := env.Head.GetStamp(StampKeyGenerated); st != nil {
+		cd.Timestamp = st.Value
+	}
+	cd.Environment = EnvelopeEnvironment(env)
+	return cd, nil
+}
+
+// removeStamps removes the stamps with the given keys from the envelope.
+func removeStamps(env *gobl.Envelope, keys ...cbc.Key) {
+	out := env.Head.Stamps[:0]
+	for _, st := range env.Head.Stamps {
+		if !st.Provider.In(keys...) {
+			out = append(out, st)
+		}
+	}
+	env.Head.Stamps = out
+}
@@ -0,0 +1,147 @@
+package verifactu_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/invopop/gobl"
+	verifactu "github.com/invopop/gobl.verifactu"
+	"github.com/invopop/gobl.verifactu/pkg/verifactutest"
+	"github.com/invopop/gobl.verifactu/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stampValue(env *gobl.Envelope, key string) string {
+	for _, st := range env.Head.Stamps {
+		if st.Provider.String() == key {
+			return st.Value
+		}
+	}
+	return ""
+}
+
+func TestStamps(t *testing.T) {
+	ts, err := time.Parse(time.RFC3339, "2024-11-26T04:00:00Z")
+	require.NoError(t, err)
+	srv := verifactutest.NewServer(verifactutest.WithCurrentTime(ts.Add(time.Minute)))
+	t.Cleanup(srv.Close)
+	c, err := verifactu.New(testSoftware, verifactu.WithCurrentTime(ts), verifactu.WithBaseURL(srv.URL))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	submit := func(t *testing.T, env *gobl.Envelope, line *verifactu.InvoiceRequestLine) *verifactu.InvoiceResult {
+		t.Helper()
+		inv := env.Extract().(*bill.Invoice)
+		ir, err := c.NewInvoiceRequest(inv.Supplier)
+		require.NoError(t, err)
+		ir.AddLine(line)
+		res, _ := c.SendInvoiceRequest(ctx, ir)
+		require.NotNil(t, res)
+		return res.Results(ir)[0]
+	}
+
+	t.Run("registration", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Equal(t, reg.Huella, stampValue(env, "verifactu-hash"))
+		assert.Equal(t, "2024-11-26T05:00:00+01:00", stampValue(env, "verifactu-generated"))
+		assert.Equal(t, "sandbox", stampValue(env, "verifactu-env"))
+		assert.Empty(t, stampValue(env, "verifactu-status"))
+
+		cd, err := verifactu.EnvelopeChainData(env)
+		require.NoError(t, err)
+		assert.Equal(t, reg.ChainData(), cd)
+
+		res := submit(t, env, &verifactu.InvoiceRequestLine{Registration: reg})
+		require.NoError(t, c.AddResultStamps(env, res))
+		assert.Equal(t, verifactu.StatusCorrect, stampValue(env, "verifactu-status"))
+		assert.Empty(t, stampValue(env, "verifactu-code"))
+		require.NotNil(t, res.Presentation)
+		assert.NotEmpty(t, res.CSV)
+		assert.Equal(t, res.CSV, stampValue(env, "verifactu-csv"))
+		assert.Equal(t, "2024-11-26T04:01:00+00:00", stampValue(env, "verifactu-submitted"), "AEAT receipt time")
+
+		// The stamps survive serialization, so the envelope can be used as
+		// the source of the chain.
+		data, err := json.Marshal(env)
+		require.NoError(t, err)
+		env2 := new(gobl.Envelope)
+		require.NoError(t, json.Unmarshal(data, env2))
+		cd, err = verifactu.EnvelopeChainData(env2)
+		require.NoError(t, err)
+		assert.Equal(t, reg.ChainData(), cd)
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		res := submit(t, env, &verifactu.InvoiceRequestLine{Registration: reg})
+		require.NoError(t, c.AddResultStamps(env, res))
+
+		can, err := c.CancelInvoice(env, reg.ChainData())
+		require.NoError(t, err)
+		assert.Equal(t, can.Huella, stampValue(env, "verifactu-cancel-hash"))
+		assert.Equal(t, reg.Huella, stampValue(env, "verifactu-hash"))
+		assert.NotEmpty(t, stampValue(env, "verifactu-qr"))
+		assert.Empty(t, stampValue(env, "verifactu-status"), "outcome of the registration removed")
+
+		cd, err := verifactu.EnvelopeChainData(env)
+		require.NoError(t, err)
+		assert.Equal(t, can.ChainData(), cd)
+
+		// the registration's result no longer matches the envelope
+		assert.ErrorIs(t, c.AddResultStamps(env, res), verifactu.ErrValidation)
+
+		res = submit(t, env, &verifactu.InvoiceRequestLine{Cancellation: can})
+		require.NoError(t, c.AddResultStamps(env, res))
+		assert.Equal(t, verifactu.StatusCorrect, stampValue(env, "verifactu-status"))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		res := &verifactu.InvoiceResult{
+			Request: &verifactu.InvoiceRequestLine{Registration: reg},
+			Response: &verifactu.InvoiceResponseLine{
+				Status: verifactu.StatusIncorrect,
+				Code:   "1100",
+			},
+		}
+		require.NoError(t, c.AddResultStamps(env, res))
+		assert.Equal(t, verifactu.StatusIncorrect, stampValue(env, "verifactu-status"))
+		assert.Equal(t, "1100", stampValue(env, "verifactu-code"))
+		assert.Empty(t, stampValue(env, "verifactu-csv"))
+		assert.Equal(t, "2024-11-26T05:00:00+01:00", stampValue(env, "verifactu-submitted"), "local time without receipt")
+
+		// a new registration clears the previous outcome
+		_, err = c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		assert.Empty(t, stampValue(env, "verifactu-status"))
+		assert.Empty(t, stampValue(env, "verifactu-code"))
+	})
+
+	t.Run("missing response", func(t *testing.T) {
+		env := test.LoadEnvelope("inv-base.json")
+		reg, err := c.RegisterInvoice(env, nil)
+		require.NoError(t, err)
+		res := &verifactu.InvoiceResult{
+			Request: &verifactu.InvoiceRequestLine{Registration: reg},
+		}
+		require.NoError(t, c.AddResultStamps(env, res))
+		assert.Empty(t, stampValue(env, "verifactu-status"))
+	})
+
+	t.Run("not stamped", func(t *testing.T) {
+		_, err := verifactu.EnvelopeChainData(test.LoadEnvelope("inv-base.json"))
+		assert.ErrorIs(t, err, verifactu.ErrMissing)
+		_, err = verifactu.EnvelopeChainData(test.LoadEnvelope("status-system-startup.json"))
+		assert.ErrorIs(t, err, verifactu.ErrOnlyInvoices)
+	})
+}
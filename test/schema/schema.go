@@ -0,0 +1,13 @@
+// Package schema embeds the AEAT XSD documents used to validate VeriFactu
+// XML documents, so that they can be used outside of this repository.
+package schema
+
+import "embed"
+
+// FS contains the bundled XSD and WSDL documents.
+//
+//go:embed *.xsd *.wsdl
+var FS embed.FS
+
+// Main is the name of the wrapper schema that imports all the others.
+const Main = "main.xsd"
@@ -4,14 +4,15 @@ package verifactu
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/invopop/gobl"
 	noverifactu "github.com/invopop/gobl.verifactu/pkg/noverifactu"
-	"github.com/invopop/gobl/addons/es/verifactu"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
-	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/currency"
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/xmldsig"
@@ -27,13 +28,20 @@ const (
 type Client struct {
 	software Software
 	env      Environment
+	mode     Mode
 	rep      *Issuer
-	curTime  time.Time
+	clock    Clock
 	cert     *xmldsig.Certificate
 	conn     *connection
+	connOpts connectionOptions
 	withSeal bool
 	signing  bool
 	signOpts []xmldsig.Option
+	refFunc  RefFunc
+	location *time.Location
+
+	agreement       string
+	agreementSystem string
 }
 
 // Option is used to configure the client.
@@ -51,7 +59,7 @@ func WithCertificate(cert *xmldsig.Certificate) Option {
 // document. Only useful for testing.
 func WithCurrentTime(curTime time.Time) Option {
 	return func(c *Client) {
-		c.curTime = curTime
+		c.clock = fixedClock(curTime)
 	}
 }
 
@@ -89,6 +97,76 @@ var WithCorporateSeal Option = func(c *Client) {
 	c.withSeal = true
 }
 
+// WithBaseURL overrides the AEAT endpoint used for all operations. This is
+// mainly useful for connecting to proxies or local test servers.
+func WithBaseURL(url string) Option {
+	return func(c *Client) {
+		c.connOpts.baseURL = url
+	}
+}
+
+// WithEndpoint defines the URL to use for a specific operation, taking
+// priority over the base URL.
+func WithEndpoint(op Operation, url string) Option {
+	return func(c *Client) {
+		if c.connOpts.endpoints == nil {
+			c.connOpts.endpoints = make(map[Operation]string)
+		}
+		c.connOpts.endpoints[op] = url
+	}
+}
+
+// WithHTTPClient sets the HTTP client to use for connections. The client is
+// copied, and when a certificate is provided, the TLS configuration of its
+// transport will be replaced on the copy.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.connOpts.httpClient = hc
+	}
+}
+
+// WithTransport sets the round tripper used to send requests, for example to
+// use a proxy or record the exchanges. Certificates will only be applied to
+// *http.Transport instances; other implementations must handle client
+// authentication themselves.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.connOpts.transport = rt
+	}
+}
+
+// WithTimeout sets the maximum duration of each request to the AEAT.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.connOpts.timeout = d
+	}
+}
+
+// WithLogger sets the structured logger used to report each exchange with
+// the AEAT. Raw payloads are only logged at debug level.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Client) {
+		c.connOpts.logger = l
+	}
+}
+
+// WithAuditHook registers a function that will receive the raw request and
+// response bodies of every exchange with the AEAT, byte for byte, so that
+// they may be persisted.
+func WithAuditHook(h AuditHook) Option {
+	return func(c *Client) {
+		c.connOpts.audit = h
+	}
+}
+
+// WithRedaction hides names and tax identifiers from log output. Payloads
+// passed to audit hooks are never modified.
+func WithRedaction() Option {
+	return func(c *Client) {
+		c.connOpts.redact = true
+	}
+}
+
 // WithSigning sets additional xmldsig options that will be passed
 // through to SignDocument for each record. This is useful for controlling
 // the document ID and signing time in tests.
@@ -112,13 +190,13 @@ func New(software Software, opts ...Option) (*Client, error) {
 		opt(c)
 	}
 
-	if c.cert == nil {
+	if c.cert == nil && !c.connOpts.custom() {
 		return c, nil
 	}
 
 	if c.conn == nil {
 		var err error
-		c.conn, err = newConnection(c.env, c.cert, c.withSeal)
+		c.conn, err = newConnection(c.env, c.cert, c.withSeal, &c.connOpts)
 		if err != nil {
 			return nil, err
 		}
@@ -130,8 +208,8 @@ func New(software Software, opts ...Option) (*Client, error) {
 // CurrentTime returns the current time to use when generating
 // the VeriFactu document.
 func (c *Client) CurrentTime() time.Time {
-	if !c.curTime.IsZero() {
-		return c.curTime
+	if c.clock != nil {
+		return c.clock.Now()
 	}
 	return time.Now()
 }
@@ -150,6 +228,11 @@ type generateOptions struct {
 	previouslyRejected string
 	noPriorRecord      string
 	installNumber      string
+	ref                string
+	coupon             string
+	generatedBy        cbc.Code
+	generator          *org.Party
+	location           *time.Location
 }
 
 // Amended indicates that the incoming document is an amendment of a previous
@@ -188,52 +271,115 @@ func WithInstallationNumber(code string) GenerateOption {
 	}
 }
 
+// GeneratedByIssuer indicates that the cancellation was generated by the
+// supplier who issued the invoice.
+func GeneratedByIssuer() GenerateOption {
+	return func(o *generateOptions) {
+		o.generatedBy = GeneratedByCodeIssuer
+		o.generator = nil
+	}
+}
+
+// GeneratedByRecipient indicates that the cancellation was generated by the
+// invoice's customer.
+func GeneratedByRecipient() GenerateOption {
+	return func(o *generateOptions) {
+		o.generatedBy = GeneratedByCodeRecipient
+		o.generator = nil
+	}
+}
+
+// GeneratedByThirdParty indicates that the cancellation was generated by a
+// third party on behalf of the supplier. When nil, the party will be taken
+// from the issuer in the invoice's ordering details.
+func GeneratedByThirdParty(p *org.Party) GenerateOption {
+	return func(o *generateOptions) {
+		o.generatedBy = GeneratedByCodeThirdParty
+		o.generator = p
+	}
+}
+
 // RegisterInvoice prepares a new registration document from the provided invoice
 // inside the GOBL envelope. It will fingerprint and update the registration with
 // the chaining hash and QR code. The resulting document can be persisted for
-// sending later.
+// sending later. The envelope's document is never modified, only stamps are
+// added to the header.
 func (c *Client) RegisterInvoice(env *gobl.Envelope, prev *ChainData, opts ...GenerateOption) (*InvoiceRegistration, error) {
+	reg, err := c.buildRegistration(env, prev, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.signing && c.cert != nil {
+		sig, err := SignDocument(reg, c.cert, c.signOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("signing registration: %w", err)
+		}
+		reg.Signature = sig
+	}
+	c.addRegistrationStamps(env, reg)
+
+	return reg, nil
+}
+
+// buildRegistration prepares the fingerprinted registration for the invoice
+// in the envelope, ready to be signed.
+func (c *Client) buildRegistration(env *gobl.Envelope, prev *ChainData, opts ...GenerateOption) (*InvoiceRegistration, error) {
 	o := new(generateOptions)
 	for _, cb := range opts {
 		cb(o)
 	}
 
-	inv, ok := env.Extract().(*bill.Invoice)
+	orig, ok := env.Extract().(*bill.Invoice)
 	if !ok {
 		return nil, ErrOnlyInvoices
 	}
-	if inv.GetRegime() != l10n.ES.Tax() {
+	if orig.GetRegime() != l10n.ES.Tax() {
 		return nil, ErrNotSpanish
 	}
 
-	if inv.Type == bill.InvoiceTypeCreditNote {
-		// In VeriFactu credit notes become "facturas rectificativas por diferencias",
-		// which require negative totals.
-		if err := inv.Invert(); err != nil {
-			return nil, err
-		}
+	inv, err := prepareInvoice(orig)
+	if err != nil {
+		return nil, err
 	}
 	software := c.software // clone
 	if o.installNumber != "" {
 		software.NumeroInstalacion = o.installNumber
 	}
 
-	reg, err := newInvoiceRegistration(inv, c.CurrentTime(), &software)
+	if err := c.checkEnvironment(EnvelopeEnvironment(env), "envelope"); err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		if err := c.checkKnownEnvironment(prev.Environment, "previous record"); err != nil {
+			return nil, err
+		}
+	}
+	ts := c.recordTime(o)
+	if err := checkInvoiceChainTime(orig, prev, ts); err != nil {
+		return nil, err
+	}
+	reg, err := newInvoiceRegistration(inv, ts, &software)
 	if err != nil {
 		return nil, err
 	}
+	reg.env = c.env
+	reg.RefExterna = c.refFor(env, o)
+	if err := c.applyAgreement(orig, reg, o); err != nil {
+		return nil, err
+	}
+	if orig.Type == bill.InvoiceTypeCreditNote {
+		reg.diagnose(DiagnosticCreditNote, "ImporteTotal", "credit note amounts inverted")
+	}
+	if orig.Currency != inv.Currency {
+		ex := currency.MatchExchangeRate(orig.ExchangeRates, orig.Currency, inv.Currency)
+		reg.diagnose(DiagnosticCurrency, "ImporteTotal", "converted from %s to %s at %s", orig.Currency, inv.Currency, ex.Amount)
+	}
+	if err := reg.normalize(); err != nil {
+		return nil, err
+	}
 	reg.Subsanacion = o.amendment
 	reg.RechazoPrevio = o.previouslyRejected
 	reg.fingerprint(prev)
-	if c.signing && c.cert != nil {
-		sig, err := SignDocument(reg, c.cert, c.signOpts...)
-		if err != nil {
-			return nil, fmt.Errorf("signing registration: %w", err)
-		}
-		reg.Signature = sig
-	}
-	c.addRegistrationStamps(env, reg)
-
 	return reg, nil
 }
 
@@ -255,9 +401,32 @@ func (c *Client) CancelInvoice(env *gobl.Envelope, prev *ChainData, opts ...Gene
 		software.NumeroInstalacion = o.installNumber
 	}
 
-	can := newInvoiceCancellation(inv, c.CurrentTime(), &software)
-	can.RechazoPrevio = o.previouslyRejected
+	if err := c.checkEnvironment(EnvelopeEnvironment(env), "envelope"); err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		if err := c.checkKnownEnvironment(prev.Environment, "previous record"); err != nil {
+			return nil, err
+		}
+	}
+	ts := c.recordTime(o)
+	if err := checkInvoiceChainTime(inv, prev, ts); err != nil {
+		return nil, err
+	}
+	can := newInvoiceCancellation(inv, ts, &software)
+	can.env = c.env
+	if o.previouslyRejected != "" {
+		// Cancellations only support the "S" value
+		can.RechazoPrevio = "S"
+	}
 	can.SinRegistroPrevio = o.noPriorRecord
+	can.RefExterna = c.refFor(env, o)
+	if err := can.setGenerator(inv, o); err != nil {
+		return nil, err
+	}
+	if err := can.normalize(); err != nil {
+		return nil, err
+	}
 	can.fingerprint(prev)
 	if c.signing && c.cert != nil {
 		sig, err := SignDocument(can, c.cert, c.signOpts...)
@@ -266,6 +435,7 @@ func (c *Client) CancelInvoice(env *gobl.Envelope, prev *ChainData, opts ...Gene
 		}
 		can.Signature = sig
 	}
+	c.addCancellationStamps(env, can)
 
 	return can, nil
 }
@@ -276,12 +446,20 @@ func (c *Client) NewInvoiceRequest(supplier *org.Party) (*InvoiceRequest, error)
 		return nil, ErrValidation.WithMessage("missing supplier or tax id")
 	}
 	ir := new(InvoiceRequest)
+	ir.env = c.env
 	ir.Header = &InvoiceRequestHeader{
 		Obligado: Issuer{
 			NombreRazon: supplier.Name,
 			NIF:         supplier.TaxID.Code.String(),
 		},
-		Representante: c.rep,
+	}
+	if c.rep != nil {
+		// copy so that normalization does not modify the client
+		rep := *c.rep
+		ir.Header.Representante = &rep
+	}
+	if err := ir.normalize(); err != nil {
+		return nil, err
 	}
 	return ir, nil
 }
@@ -304,26 +482,45 @@ func (c *Client) NewEnvelopeInvoiceRequest(env *gobl.Envelope, prev *ChainData,
 }
 
 // SendInvoiceRequest will prepare the final SOAP envelope with the invoice request
-// data and send it the agency API.
+// data and send it the agency API. If any of the lines were rejected, the response
+// will be returned alongside a *SubmissionError.
 func (c *Client) SendInvoiceRequest(ctx context.Context, ir *InvoiceRequest) (*InvoiceResponse, error) {
 	if len(ir.Lines) == 0 {
 		return nil, ErrValidation.WithMessage("no invoice request lines")
 	}
+	if err := c.checkRequestEnvironment(ir); err != nil {
+		return nil, err
+	}
+
+	if c.conn == nil {
+		return nil, ErrConnection.WithMessage("no connection available, certificate required")
+	}
 
 	data, err := ir.Envelop().Bytes()
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := c.conn.post(ctx, data)
+	var nifs []string
+	if h := ir.Header; h != nil {
+		nifs = append(nifs, h.Obligado.NIF)
+		if h.Representante != nil {
+			nifs = append(nifs, h.Representante.NIF)
+		}
+	}
+	out, err := c.conn.post(ctx, OperationRegister, data, nifs)
 	if err != nil {
 		return nil, err
 	}
 
-	if res := out.Body.InvoiceResponse; res == nil {
+	res := out.Body.InvoiceResponse
+	if res == nil {
 		return nil, ErrConnection.WithMessage("missing response body")
 	}
-	return out.Body.InvoiceResponse, nil
+	if err := res.Error(); err != nil {
+		return res, err
+	}
+	return res, nil
 }
 
 // RegisterEvent prepares a new event registration document from the provided bill status
@@ -352,10 +549,22 @@ func (c *Client) RegisterEvent(env *gobl.Envelope, prev *EventChainData, opts ..
 		software.NumeroInstalacion = o.installNumber
 	}
 
-	reg, err := newEventRegistration(status, c.CurrentTime(), &software)
+	ts := c.recordTime(o)
+	if prev != nil {
+		if err := c.checkKnownEnvironment(prev.Environment, "previous event"); err != nil {
+			return nil, err
+		}
+		// An anomaly event would suffer from the same problem, so only the
+		// error is returned, without an Event. See DateAnomalyError.
+		if de := checkChainTime(prev.GenerationTimestamp, ts); de != nil {
+			return nil, de
+		}
+	}
+	reg, err := newEventRegistration(status, ts, &software)
 	if err != nil {
 		return nil, fmt.Errorf("creating event registration: %w", err)
 	}
+	reg.env = c.env
 	reg.Event.fingerprint(prev)
 
 	if c.signing && c.cert != nil {
@@ -370,27 +579,3 @@ func (c *Client) RegisterEvent(env *gobl.Envelope, prev *EventChainData, opts ..
 
 	return reg, nil
 }
-
-// addEventStamps adds the Hash stamp to the envelope for event registrations.
-func (c *Client) addEventStamps(env *gobl.Envelope, reg *EventRegistration) {
-	if reg.Event != nil {
-		env.Head.AddStamp(&head.Stamp{
-			Provider: StampKeyHash,
-			Value:    reg.Event.Fingerprint,
-		})
-	}
-}
-
-// addRegistrationStamps adds the QR code stamp and Hash to the envelope.
-func (c *Client) addRegistrationStamps(env *gobl.Envelope, reg *InvoiceRegistration) {
-	// now generate the QR codes and add them to the envelope
-	code := reg.generateURL(c.env == EnvironmentProduction)
-	env.Head.AddStamp(&head.Stamp{
-		Provider: verifactu.StampQR,
-		Value:    code,
-	})
-	env.Head.AddStamp(&head.Stamp{
-		Provider: StampKeyHash,
-		Value:    reg.Huella,
-	})
-}